@@ -0,0 +1,168 @@
+/*
+Command natsrec records the messages published to NATS subjects and replays
+them, to capture and reproduce event flows while debugging.
+
+Usage:
+
+	natsrec record [-server url] [-o file] [-duration d] subject...
+	natsrec replay [-server url] [-speed x] file
+
+Records are stored as newline-delimited JSON with the subject, headers,
+base64 payload and time of reception of every message. Recording stops on
+interrupt or after the duration. Replay publishes the records at their
+original pace, multiplied by speed; a speed of 0 replays them as fast as
+possible.
+
+The connection is configured by the NATS_* environment variables, see
+pubsubnats.ConfigFromEnv, and -server overrides NATS_URL.
+
+Example, against a local nats-server:
+
+	natsrec record -o orders.jsonl 'orders.>'
+	natsrec replay -speed 10 orders.jsonl
+*/
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	pubsubnats "github.com/etherlabsio/pkg/pubsub/nats"
+)
+
+func main() {
+	logger := log.With(log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr)), "ts", log.DefaultTimestampUTC)
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var err error
+	switch os.Args[1] {
+	case "record":
+		err = runRecord(ctx, os.Args[2:], logger)
+	case "replay":
+		err = runReplay(ctx, os.Args[2:], logger)
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		level.Error(logger).Log("cmd", os.Args[1], "err", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage:")
+	fmt.Fprintln(os.Stderr, "  natsrec record [-server url] [-o file] [-duration d] subject...")
+	fmt.Fprintln(os.Stderr, "  natsrec replay [-server url] [-speed x] file")
+}
+
+func connect(ctx context.Context, server string, logger log.Logger) (*pubsubnats.Conn, error) {
+	cfg := pubsubnats.ConfigFromEnv()
+	if cfg.Name == "" {
+		cfg.Name = "natsrec"
+	}
+	if server != "" {
+		cfg.URLs = strings.Split(server, ",")
+	}
+	cfg.Logger = logger
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	return pubsubnats.Connect(ctx, cfg)
+}
+
+func runRecord(ctx context.Context, args []string, logger log.Logger) error {
+	fs := flag.NewFlagSet("record", flag.ExitOnError)
+	var (
+		server   = fs.String("server", "", "NATS server URLs, comma separated (default $NATS_URL)")
+		output   = fs.String("o", "-", "output file, - for stdout")
+		duration = fs.Duration("duration", 0, "recording duration, 0 until interrupted")
+	)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	nc, err := connect(ctx, *server, logger)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	if *duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+	rec := newRecorder(w)
+	level.Info(logger).Log("msg", "recording", "subjects", strings.Join(fs.Args(), ","))
+	err = record(ctx, nc.Conn, fs.Args(), rec, logger)
+	if ferr := rec.flush(); err == nil {
+		err = ferr
+	}
+	level.Info(logger).Log("msg", "recorded", "messages", rec.count())
+	return err
+}
+
+func runReplay(ctx context.Context, args []string, logger log.Logger) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var (
+		server = fs.String("server", "", "NATS server URLs, comma separated (default $NATS_URL)")
+		speed  = fs.Float64("speed", 1, "pace multiplier, 0 for as fast as possible")
+	)
+	fs.Parse(args)
+	if fs.NArg() != 1 || *speed < 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	nc, err := connect(ctx, *server, logger)
+	if err != nil {
+		return err
+	}
+	defer nc.Close()
+
+	p := pubsubnats.NewPublisher(nc.Conn,
+		pubsubnats.PublisherEncoder(encodeRecord),
+		pubsubnats.PublisherLogger(logger),
+	)
+	n, err := replay(ctx, p, f, *speed)
+	if ferr := nc.Flush(); err == nil {
+		err = ferr
+	}
+	level.Info(logger).Log("msg", "replayed", "messages", n)
+	return err
+}
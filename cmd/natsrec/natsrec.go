@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats.go"
+
+	pubsubnats "github.com/etherlabsio/pkg/pubsub/nats"
+)
+
+// Record is a recorded message, stored as a JSON line.
+type Record struct {
+	Subject string      `json:"subject"`
+	Header  nats.Header `json:"header,omitempty"`
+	Data    []byte      `json:"data"`
+	Time    time.Time   `json:"time"`
+}
+
+// recorder writes the messages it serves to w as JSON lines. It is safe for
+// concurrent use.
+type recorder struct {
+	mu  sync.Mutex
+	w   *bufio.Writer
+	enc *json.Encoder
+	n   int
+}
+
+func newRecorder(w io.Writer) *recorder {
+	bw := bufio.NewWriter(w)
+	return &recorder{w: bw, enc: json.NewEncoder(bw)}
+}
+
+func (r *recorder) write(rec Record) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.enc.Encode(rec); err != nil {
+		return errors.WithKind(err, errors.IO, "write record")
+	}
+	r.n++
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.n
+}
+
+func (r *recorder) flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.w.Flush()
+}
+
+// handler returns a handler recording the messages.
+func (r *recorder) handler(logger log.Logger) pubsubnats.Handler {
+	return pubsubnats.NewSubscriber(
+		func(_ context.Context, request interface{}) (interface{}, error) {
+			return nil, r.write(request.(Record))
+		},
+		decodeRecord,
+		pubsubnats.SubscriberErrorLogger(logger),
+	)
+}
+
+func decodeRecord(_ context.Context, msg *nats.Msg) (interface{}, error) {
+	return Record{
+		Subject: msg.Subject,
+		Header:  msg.Header,
+		Data:    msg.Data,
+		Time:    time.Now().UTC(),
+	}, nil
+}
+
+// record records the messages published to subjects with r until ctx is done.
+// Messages still pending in the subscriptions at that point are dropped.
+func record(ctx context.Context, nc *nats.Conn, subjects []string, r *recorder, logger log.Logger) error {
+	const op errors.Op = "natsrec.record"
+	h := r.handler(logger).ServeMsg(nc)
+	var subs []*nats.Subscription
+	defer func() {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+	}()
+	for _, subject := range subjects {
+		sub, err := nc.Subscribe(subject, h)
+		if err != nil {
+			return errors.New("subscribe to "+subject, err, op, errors.IO)
+		}
+		subs = append(subs, sub)
+	}
+	if err := nc.Flush(); err != nil {
+		return errors.New("subscribe", err, op, errors.IO)
+	}
+	<-ctx.Done()
+	return nil
+}
+
+// replay publishes the records read from r with p, spacing them as recorded
+// divided by speed. A speed of 0 publishes them as fast as possible. It
+// returns the number of published records.
+func replay(ctx context.Context, p *pubsubnats.Publisher, r io.Reader, speed float64) (int, error) {
+	const op errors.Op = "natsrec.replay"
+	dec := json.NewDecoder(r)
+	var (
+		n     int
+		first time.Time
+		start = time.Now()
+	)
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, errors.New("decode record", err, op, errors.Invalid)
+		}
+
+		if n == 0 {
+			first = rec.Time
+		}
+		if speed > 0 {
+			due := start.Add(time.Duration(float64(rec.Time.Sub(first)) / speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return n, ctx.Err()
+				case <-time.After(wait):
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		if err := p.Publish(ctx, rec.Subject, rec); err != nil {
+			return n, errors.New("publish to "+rec.Subject, err, op, errors.IO)
+		}
+		n++
+	}
+}
+
+// encodeRecord is an EncodeRequestFunc restoring the headers and payload of a
+// Record.
+func encodeRecord(_ context.Context, msg *nats.Msg, request interface{}) error {
+	rec, ok := request.(Record)
+	if !ok {
+		return errors.New("natsrec: request is not a Record", errors.Invalid)
+	}
+	msg.Header = rec.Header
+	msg.Data = rec.Data
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pubsubnats "github.com/etherlabsio/pkg/pubsub/nats"
+)
+
+func runServer(t *testing.T) *nats.Conn {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	s := natsserver.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	t.Cleanup(nc.Close)
+	return nc
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	nc := runServer(t)
+
+	var buf bytes.Buffer
+	rec := newRecorder(&buf)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- record(ctx, nc, []string{"orders.>", "users.created"}, rec, log.NewNopLogger()) }()
+
+	require.Eventually(t, func() bool { return nc.NumSubscriptions() == 2 }, time.Second, 10*time.Millisecond)
+	msg := nats.NewMsg("orders.created")
+	msg.Header.Set("Trace-Id", "abc")
+	msg.Data = []byte(`{"id":"1"}`)
+	require.NoError(t, nc.PublishMsg(msg))
+	require.Eventually(t, func() bool { return rec.count() == 1 }, time.Second, 10*time.Millisecond)
+	require.NoError(t, nc.Publish("users.created", []byte("raw")))
+	require.NoError(t, nc.Publish("ignored", []byte("x")))
+	require.Eventually(t, func() bool { return rec.count() == 2 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+	require.NoError(t, rec.flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var first Record
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &first))
+	assert.Equal(t, "orders.created", first.Subject)
+	assert.Equal(t, "abc", first.Header.Get("Trace-Id"))
+	assert.Equal(t, `{"id":"1"}`, string(first.Data))
+	assert.False(t, first.Time.IsZero())
+
+	replayed := make(chan *nats.Msg, 10)
+	sub, err := nc.ChanSubscribe(">", replayed)
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	p := pubsubnats.NewPublisher(nc, pubsubnats.PublisherEncoder(encodeRecord))
+	n, err := replay(context.Background(), p, &buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	got := <-replayed
+	assert.Equal(t, "orders.created", got.Subject)
+	assert.Equal(t, "abc", got.Header.Get("Trace-Id"))
+	assert.Equal(t, `{"id":"1"}`, string(got.Data))
+	got = <-replayed
+	assert.Equal(t, "users.created", got.Subject)
+	assert.Equal(t, "raw", string(got.Data))
+}
+
+func TestReplay_Pace(t *testing.T) {
+	nc := runServer(t)
+	p := pubsubnats.NewPublisher(nc, pubsubnats.PublisherEncoder(encodeRecord))
+
+	var buf bytes.Buffer
+	begin := time.Now()
+	enc := json.NewEncoder(&buf)
+	require.NoError(t, enc.Encode(Record{Subject: "a", Time: begin}))
+	require.NoError(t, enc.Encode(Record{Subject: "b", Time: begin.Add(200 * time.Millisecond)}))
+
+	start := time.Now()
+	n, err := replay(context.Background(), p, bytes.NewReader(buf.Bytes()), 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	n, err = replay(ctx, p, bytes.NewReader(buf.Bytes()), 1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, n)
+}
@@ -17,6 +17,7 @@ package env
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -38,6 +39,22 @@ func Bool(key string, def bool) bool {
 	return def
 }
 
+// Int returns the environment variable value specified by the key parameter,
+// otherwise returning a default value if set.
+// If the int value cannot be parsed, Int will exit the program with an error
+// status.
+func Int(key string, def int) int {
+	if env, ok := os.LookupEnv(key); ok {
+		i, err := strconv.Atoi(env)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "env: parse int from flag: %s\n", err)
+			os.Exit(1)
+		}
+		return i
+	}
+	return def
+}
+
 // Duration returns the environment variable value specified by the key parameter,
 // otherwise returning a default value if set.
 // If the time.Duration value cannot be parsed, Duration will exit the program
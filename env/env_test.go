@@ -98,3 +98,18 @@ func TestBool(t *testing.T) {
 		t.Errorf("have %v, want %v", have, want)
 	}
 }
+
+func TestInt(t *testing.T) {
+	key := "TEST_INT"
+	if err := os.Setenv(key, "-1"); err != nil {
+		t.Fatalf("failed to set env var %s for test: %s\n", key, err)
+	}
+	if have, want := Int(key, 10), -1; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+
+	// test default value
+	if have, want := Int("TEST_DEFAULT", 10), 10; have != want {
+		t.Errorf("have %d, want %d", have, want)
+	}
+}
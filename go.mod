@@ -1,28 +1,52 @@
 module github.com/etherlabsio/pkg
 
 require (
-	github.com/alicebob/gopher-json v0.0.0-20180125190556-5a6b3ba71ee6 // indirect
-	github.com/alicebob/miniredis v2.5.0+incompatible
+	github.com/alicebob/miniredis/v2 v2.37.0
 	github.com/bsm/redislock v0.4.0
 	github.com/etherlabsio/errors v0.2.3
 	github.com/go-kit/kit v0.9.0
-	github.com/go-logfmt/logfmt v0.3.0 // indirect
 	github.com/go-redis/cache v6.4.0+incompatible
 	github.com/go-redis/redis v6.15.6+incompatible
+	github.com/mattn/go-sqlite3 v1.14.32
+	github.com/nats-io/jwt/v2 v2.5.2
+	github.com/nats-io/nats-server/v2 v2.10.4
+	github.com/nats-io/nats.go v1.31.0
+	github.com/nats-io/nkeys v0.4.6
+	github.com/nats-io/nuid v1.0.1
+	github.com/stretchr/testify v1.8.4
+	github.com/vmihailenco/msgpack v4.0.4+incompatible
+	github.com/xeipuuv/gojsonschema v1.2.0
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/sdk v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+	golang.org/x/net v0.10.0
+	google.golang.org/protobuf v1.36.5
+)
+
+require (
+	github.com/VividCortex/gohistogram v1.0.0 // indirect
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/go-logfmt/logfmt v0.3.0 // indirect
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/go-stack/stack v1.8.0 // indirect
-	github.com/gomodule/redigo v2.0.0+incompatible // indirect
+	github.com/golang/protobuf v1.5.0 // indirect
+	github.com/klauspost/compress v1.17.2 // indirect
 	github.com/kr/logfmt v0.0.0-20140226030751-b84e30acd515 // indirect
+	github.com/minio/highwayhash v1.0.2 // indirect
 	github.com/nats-io/gnatsd v1.4.1 // indirect
-	github.com/nats-io/go-nats v1.6.0 // indirect
 	github.com/nats-io/nats-server v1.4.1 // indirect
-	github.com/nats-io/nats-server/v2 v2.0.0 // indirect
-	github.com/nats-io/nats.go v1.8.1
-	github.com/pkg/errors v0.8.1
-	github.com/stretchr/testify v1.4.0
-	github.com/vmihailenco/msgpack v4.0.4+incompatible
-	github.com/yuin/gopher-lua v0.0.0-20181214045814-db9ae37725ec // indirect
-	golang.org/x/net v0.0.0-20190620200207-3b0461eec859
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/xeipuuv/gojsonpointer v0.0.0-20180127040702-4e3ac2762d5f // indirect
+	github.com/xeipuuv/gojsonreference v0.0.0-20180127040603-bd5ef7bd5415 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	go.opentelemetry.io/otel/metric v1.24.0 // indirect
+	golang.org/x/crypto v0.14.0 // indirect
+	golang.org/x/sys v0.17.0 // indirect
+	golang.org/x/time v0.3.0 // indirect
 	google.golang.org/appengine v1.4.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
-go 1.13
+go 1.21
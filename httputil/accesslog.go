@@ -0,0 +1,199 @@
+package httputil
+
+import (
+	"bufio"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+)
+
+// RequestIDHeader is the header carrying the ID of a request.
+const RequestIDHeader = "X-Request-ID"
+
+type accessLog struct {
+	sampling     float64
+	exclude      map[string]bool
+	forwardedFor bool
+	requestID    func(w http.ResponseWriter, r *http.Request) string
+}
+
+// AccessLogOption sets an optional parameter for the access log.
+type AccessLogOption func(*accessLog)
+
+// AccessLogSampling logs only the given fraction, between 0 and 1, of the
+// successful requests. Client and server errors are always logged. It defaults
+// to 1.
+func AccessLogSampling(rate float64) AccessLogOption {
+	return func(a *accessLog) { a.sampling = rate }
+}
+
+// AccessLogExclude disables the logging of requests to paths, such as health
+// checks, unless they fail with a server error.
+func AccessLogExclude(paths ...string) AccessLogOption {
+	return func(a *accessLog) {
+		for _, p := range paths {
+			a.exclude[p] = true
+		}
+	}
+}
+
+// AccessLogForwardedFor takes the remote IP from the X-Forwarded-For header,
+// set by trusted proxies in front of the service.
+func AccessLogForwardedFor() AccessLogOption {
+	return func(a *accessLog) { a.forwardedFor = true }
+}
+
+// AccessLogRequestID sets the function returning the ID of the request. It
+// defaults to the X-Request-ID header of the response, or of the request if
+// the response has none.
+func AccessLogRequestID(f func(w http.ResponseWriter, r *http.Request) string) AccessLogOption {
+	return func(a *accessLog) { a.requestID = f }
+}
+
+func headerRequestID(w http.ResponseWriter, r *http.Request) string {
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		return id
+	}
+	return r.Header.Get(RequestIDHeader)
+}
+
+// AccessLog returns a Middleware logging the method, path, status, response
+// size, latency, remote IP and request ID of every request. Server errors are
+// logged at the error level, client errors at the warn level and other
+// requests at the info level, as logutil.WithError does.
+func AccessLog(logger log.Logger, opts ...AccessLogOption) Middleware {
+	a := &accessLog{
+		sampling:  1,
+		exclude:   map[string]bool{},
+		requestID: headerRequestID,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			begin := time.Now()
+			sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+			// log in a defer, so that panicking handlers are logged as server
+			// errors when a Recovery middleware sits outside
+			panicked := true
+			defer func() {
+				if panicked && !sw.wroteHeader {
+					sw.status = http.StatusInternalServerError
+				}
+				a.log(logger, w, r, sw, time.Since(begin))
+			}()
+			next.ServeHTTP(sw, r)
+			panicked = false
+		})
+	}
+}
+
+func (a *accessLog) log(logger log.Logger, w http.ResponseWriter, r *http.Request, sw *statusWriter, took time.Duration) {
+	if !a.logged(r, sw.status) {
+		return
+	}
+	var l log.Logger
+	switch {
+	case sw.status >= 500:
+		l = level.Error(logger)
+	case sw.status >= 400:
+		l = level.Warn(logger)
+	default:
+		l = level.Info(logger)
+	}
+	l.Log(
+		"component", "http",
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", sw.status,
+		"bytes", sw.bytes,
+		"took", took,
+		"remote_ip", a.remoteIP(r),
+		"request_id", a.requestID(w, r),
+	)
+}
+
+func (a *accessLog) logged(r *http.Request, status int) bool {
+	if status >= 500 {
+		return true
+	}
+	if a.exclude[r.URL.Path] {
+		return false
+	}
+	return status >= 400 || a.sampling >= 1 || rand.Float64() < a.sampling
+}
+
+func (a *accessLog) remoteIP(r *http.Request) string {
+	if a.forwardedFor {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			return strings.TrimSpace(strings.Split(xff, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusWriter records the status and size of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Flush implements http.Flusher if the underlying writer does.
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.wroteHeader = true
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, failing with http.ErrNotSupported if the
+// underlying writer does not.
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	w.wroteHeader = true
+	return h.Hijack()
+}
+
+// Push implements http.Pusher, failing with http.ErrNotSupported if the
+// underlying writer does not.
+func (w *statusWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap gives http.ResponseController access to the underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
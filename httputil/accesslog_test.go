@@ -0,0 +1,168 @@
+package httputil
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestAccessLog(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		opts   []AccessLogOption
+		want   map[string]string // nil when not logged
+	}{
+		{
+			name:   "ok",
+			path:   "/orders",
+			status: http.StatusOK,
+			want:   map[string]string{"level": "info", "status": "200", "bytes": "5", "path": "/orders"},
+		},
+		{
+			name:   "client error",
+			path:   "/orders",
+			status: http.StatusNotFound,
+			want:   map[string]string{"level": "warn", "status": "404"},
+		},
+		{
+			name:   "server error",
+			path:   "/orders",
+			status: http.StatusBadGateway,
+			want:   map[string]string{"level": "error", "status": "502"},
+		},
+		{
+			name:   "excluded",
+			path:   "/healthz",
+			status: http.StatusOK,
+			opts:   []AccessLogOption{AccessLogExclude("/healthz")},
+		},
+		{
+			name:   "excluded server error",
+			path:   "/healthz",
+			status: http.StatusServiceUnavailable,
+			opts:   []AccessLogOption{AccessLogExclude("/healthz")},
+			want:   map[string]string{"level": "error", "status": "503"},
+		},
+		{
+			name:   "sampled out",
+			path:   "/orders",
+			status: http.StatusOK,
+			opts:   []AccessLogOption{AccessLogSampling(0)},
+		},
+		{
+			name:   "client error not sampled",
+			path:   "/orders",
+			status: http.StatusBadRequest,
+			opts:   []AccessLogOption{AccessLogSampling(0)},
+			want:   map[string]string{"level": "warn", "status": "400"},
+		},
+		{
+			name:   "forwarded for",
+			path:   "/orders",
+			status: http.StatusOK,
+			opts:   []AccessLogOption{AccessLogForwardedFor()},
+			want:   map[string]string{"remote_ip": "203.0.113.7"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]string
+			logger := log.LoggerFunc(func(keyvals ...interface{}) error {
+				got = map[string]string{}
+				for i := 0; i < len(keyvals); i += 2 {
+					got[fmt.Sprint(keyvals[i])] = fmt.Sprint(keyvals[i+1])
+				}
+				return nil
+			})
+			h := AccessLog(logger, tt.opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("hello"))
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			r.Header.Set(RequestIDHeader, "abc")
+			r.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if tt.want == nil {
+				if got != nil {
+					t.Fatalf("unexpected log: %v", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("request not logged")
+			}
+			want := map[string]string{"method": "GET", "remote_ip": "192.0.2.1", "request_id": "abc"}
+			for k, v := range tt.want {
+				want[k] = v
+			}
+			for k, v := range want {
+				if got[k] != v {
+					t.Errorf("%s: have %q, want %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestAccessLog_PanicInsideRecovery(t *testing.T) {
+	var status interface{}
+	logger := log.LoggerFunc(func(keyvals ...interface{}) error {
+		for i := 0; i < len(keyvals); i += 2 {
+			if keyvals[i] == "status" {
+				status = keyvals[i+1]
+			}
+		}
+		return nil
+	})
+	h := Chain(Recovery(log.NewNopLogger(), nil), AccessLog(logger))(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("something went wrong")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status: have %d, want %d", w.Code, http.StatusInternalServerError)
+	}
+	if status != http.StatusInternalServerError {
+		t.Errorf("logged status: have %v, want %d", status, http.StatusInternalServerError)
+	}
+}
+
+func TestAccessLog_Hijack(t *testing.T) {
+	h := AccessLog(log.NewNopLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack: %v", err)
+			return
+		}
+		conn.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"))
+		conn.Close()
+	}))
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "ok" {
+		t.Errorf("body: have %q, want %q", body, "ok")
+	}
+
+	_, _, err = (&statusWriter{ResponseWriter: httptest.NewRecorder()}).Hijack()
+	if err != http.ErrNotSupported {
+		t.Errorf("hijack of recorder: have %v, want %v", err, http.ErrNotSupported)
+	}
+}
@@ -1,6 +1,13 @@
 package httputil
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+
+	"github.com/etherlabsio/pkg/logutil"
+)
 
 // Middleware is a chainable decorator for HTTP Handlers.
 type Middleware func(http.Handler) http.Handler
@@ -18,3 +25,26 @@ func Chain(outer Middleware, others ...Middleware) Middleware {
 		return outer(next)
 	}
 }
+
+// Recovery returns a Middleware converting panics of the handler into
+// errors.Internal errors, which are logged along with their stack, counted in
+// panics if not nil and replied as a 500 JSON error. See
+// logutil.RecoveryMiddleware for the endpoint equivalent.
+func Recovery(logger log.Logger, panics metrics.Counter) Middleware {
+	encodeError := JSONErrorEncoder(func(error) int { return http.StatusInternalServerError })
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if rvr := recover(); rvr != nil {
+					if rvr == http.ErrAbortHandler {
+						panic(rvr)
+					}
+					err := logutil.PanicError(rvr)
+					logutil.LogPanic(logger, panics, err, "component", "http", "method", r.Method, "path", r.URL.Path)
+					encodeError(r.Context(), err, w)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -1,9 +1,15 @@
 package httputil
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"testing"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/generic"
 )
 
 func ExampleChain() {
@@ -39,3 +45,31 @@ func myHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 	})
 }
+
+func TestRecovery(t *testing.T) {
+	panics := generic.NewCounter("panics")
+	h := Recovery(log.NewNopLogger(), panics)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("something went wrong")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if have, want := w.Code, http.StatusInternalServerError; have != want {
+		t.Errorf("status: have %d, want %d", have, want)
+	}
+	var body struct {
+		Error struct {
+			Code errors.Kind `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if have, want := body.Error.Code, errors.Internal; have != want {
+		t.Errorf("error code: have %v, want %v", have, want)
+	}
+	if have, want := panics.Value(), 1.0; have != want {
+		t.Errorf("panics: have %v, want %v", have, want)
+	}
+}
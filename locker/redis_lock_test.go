@@ -10,7 +10,7 @@ import (
 
 	"github.com/go-redis/redis"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 )
 
 // Additional test cases can be found at https://github.com/bsm/redislock/blob/master/redislock_test.go
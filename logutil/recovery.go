@@ -0,0 +1,49 @@
+package logutil
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+)
+
+// PanicError converts a value recovered from a panic into an errors.Internal
+// error.
+func PanicError(rvr interface{}) error {
+	if err, ok := rvr.(error); ok {
+		return errors.New("panic", err, errors.Internal)
+	}
+	return errors.New(fmt.Sprintf("panic: %v", rvr), errors.Internal)
+}
+
+// LogPanic logs err, as returned by PanicError, along with the stack of the
+// panicking goroutine, and counts it in panics if not nil. It must be called
+// from the deferred function recovering the panic.
+func LogPanic(logger log.Logger, panics metrics.Counter, err error, keyvals ...interface{}) {
+	if panics != nil {
+		panics.Add(1)
+	}
+	keyvals = append(keyvals, "stack", string(debug.Stack()))
+	WithError(logger, err).Log(keyvals...)
+}
+
+// RecoveryMiddleware returns an endpoint middleware that converts panics of
+// the endpoint into errors.Internal errors, logged along with their stack and
+// counted in panics if not nil.
+func RecoveryMiddleware(logger log.Logger, panics metrics.Counter) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+			defer func() {
+				if rvr := recover(); rvr != nil {
+					err = PanicError(rvr)
+					LogPanic(logger, panics, err, "component", "endpoint")
+				}
+			}()
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package logutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/generic"
+)
+
+func TestRecoveryMiddleware(t *testing.T) {
+	panics := generic.NewCounter("panics")
+	var logged []interface{}
+	logger := log.LoggerFunc(func(keyvals ...interface{}) error {
+		logged = keyvals
+		return nil
+	})
+
+	var tests = []struct {
+		name  string
+		value interface{}
+	}{
+		{"string", "something went wrong"},
+		{"error", errors.Str("something went wrong")},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := RecoveryMiddleware(logger, panics)(func(context.Context, interface{}) (interface{}, error) {
+				panic(tt.value)
+			})
+			_, err := e(context.Background(), nil)
+			if err == nil {
+				t.Fatal("want error, have nil")
+			}
+			if !errors.IsKind(err, errors.Internal) {
+				t.Errorf("want Internal error, have %v", errors.KindOf(err))
+			}
+			if have, want := panics.Value(), float64(i+1); have != want {
+				t.Errorf("panics: have %v, want %v", have, want)
+			}
+			if len(logged) == 0 {
+				t.Error("panic not logged")
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package natsutil
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	natstransport "github.com/go-kit/kit/transport/nats"
+	nats "github.com/nats-io/nats.go"
+)
+
+// CloudEvents content type and version.
+const (
+	ContentTypeCloudEventsJSON = "application/cloudevents+json"
+	CloudEventsSpecVersion     = "1.0"
+)
+
+// Headers carrying the event attributes in binary content mode.
+const (
+	CloudEventIDHdr          = "ce-id"
+	CloudEventSourceHdr      = "ce-source"
+	CloudEventSpecVersionHdr = "ce-specversion"
+	CloudEventTypeHdr        = "ce-type"
+	CloudEventSubjectHdr     = "ce-subject"
+	CloudEventTimeHdr        = "ce-time"
+)
+
+// CloudEvent holds the context attributes of a CloudEvents 1.0 event.
+//
+// see https://github.com/cloudevents/spec/blob/v1.0/spec.md
+type CloudEvent struct {
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	SpecVersion     string    `json:"specversion"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype,omitempty"`
+}
+
+type structuredCloudEvent struct {
+	CloudEvent
+	Data       json.RawMessage `json:"data,omitempty"`
+	DataBase64 []byte          `json:"data_base64,omitempty"`
+}
+
+// WriteCloudEvent sets the payload of msg to the event with the encoded data.
+// In binary mode the attributes are carried in the ce-* headers and the data
+// is the payload; otherwise the event is written in structured JSON mode.
+func WriteCloudEvent(msg *nats.Msg, ce CloudEvent, data []byte, binary bool) error {
+	if ce.SpecVersion == "" {
+		ce.SpecVersion = CloudEventsSpecVersion
+	}
+	if msg.Header == nil {
+		msg.Header = nats.Header{}
+	}
+
+	if binary {
+		msg.Header.Set(CloudEventIDHdr, ce.ID)
+		msg.Header.Set(CloudEventSourceHdr, ce.Source)
+		msg.Header.Set(CloudEventSpecVersionHdr, ce.SpecVersion)
+		msg.Header.Set(CloudEventTypeHdr, ce.Type)
+		if ce.Subject != "" {
+			msg.Header.Set(CloudEventSubjectHdr, ce.Subject)
+		}
+		if !ce.Time.IsZero() {
+			msg.Header.Set(CloudEventTimeHdr, ce.Time.Format(time.RFC3339Nano))
+		}
+		if ce.DataContentType != "" {
+			msg.Header.Set(ContentTypeHdr, ce.DataContentType)
+		}
+		msg.Data = data
+		return nil
+	}
+
+	event := structuredCloudEvent{CloudEvent: ce}
+	if _, ok := codecFor(ce.DataContentType, []Codec{JSONCodec}); ok {
+		event.Data = data
+	} else {
+		event.DataBase64 = data
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	msg.Header.Set(ContentTypeHdr, ContentTypeCloudEventsJSON)
+	msg.Data = b
+	return nil
+}
+
+// ReadCloudEvent reads the event attributes and the encoded data of msg in
+// either content mode. It returns false if msg is not a CloudEvent.
+func ReadCloudEvent(msg *nats.Msg) (ce CloudEvent, data []byte, ok bool, err error) {
+	if spec := msg.Header.Get(CloudEventSpecVersionHdr); spec != "" {
+		ce = CloudEvent{
+			ID:              msg.Header.Get(CloudEventIDHdr),
+			Source:          msg.Header.Get(CloudEventSourceHdr),
+			SpecVersion:     spec,
+			Type:            msg.Header.Get(CloudEventTypeHdr),
+			Subject:         msg.Header.Get(CloudEventSubjectHdr),
+			DataContentType: msg.Header.Get(ContentTypeHdr),
+		}
+		if t := msg.Header.Get(CloudEventTimeHdr); t != "" {
+			if ce.Time, err = time.Parse(time.RFC3339Nano, t); err != nil {
+				return ce, nil, true, errors.WithMessagef(err, "invalid cloudevent time for subject %s", msg.Subject)
+			}
+		}
+		return ce, msg.Data, true, nil
+	}
+
+	if MediaType(msg.Header.Get(ContentTypeHdr)) != ContentTypeCloudEventsJSON {
+		return ce, msg.Data, false, nil
+	}
+
+	var event structuredCloudEvent
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return ce, nil, true, errors.WithMessagef(err, "invalid cloudevent for subject %s", msg.Subject)
+	}
+	if event.DataBase64 != nil {
+		return event.CloudEvent, event.DataBase64, true, nil
+	}
+	return event.CloudEvent, event.Data, true, nil
+}
+
+type contextKey int
+
+const cloudEventKey contextKey = iota
+
+// ContextWithCloudEvent returns a copy of ctx carrying the event attributes.
+func ContextWithCloudEvent(ctx context.Context, ce CloudEvent) context.Context {
+	return context.WithValue(ctx, cloudEventKey, ce)
+}
+
+// CloudEventFromContext returns the attributes of the CloudEvent being served.
+func CloudEventFromContext(ctx context.Context) (CloudEvent, bool) {
+	ce, ok := ctx.Value(cloudEventKey).(CloudEvent)
+	return ce, ok
+}
+
+// CloudEventToContext is a RequestFunc exposing the attributes of CloudEvents
+// in either content mode to the endpoint, see CloudEventFromContext. Use it
+// along with DecodeNATSEvent or Registry.Decoder, which unwrap the data.
+func CloudEventToContext() natstransport.RequestFunc {
+	return func(ctx context.Context, msg *nats.Msg) context.Context {
+		ce, _, ok, err := ReadCloudEvent(msg)
+		if !ok || err != nil {
+			return ctx
+		}
+		return ContextWithCloudEvent(ctx, ce)
+	}
+}
@@ -0,0 +1,90 @@
+package natsutil
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/etherlabsio/errors"
+	"github.com/vmihailenco/msgpack"
+	"google.golang.org/protobuf/proto"
+)
+
+// ContentTypeHdr is the message header carrying the content type of the payload.
+const ContentTypeHdr = "Content-Type"
+
+// Content types of the built-in codecs.
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeMsgpack  = "application/msgpack"
+	ContentTypeProtobuf = "application/protobuf"
+)
+
+// Codec serializes message payloads of a content type.
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Built-in codecs.
+var (
+	JSONCodec     Codec = jsonCodec{}
+	MsgpackCodec  Codec = msgpackCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+var defaultCodecs = []Codec{JSONCodec, MsgpackCodec, ProtobufCodec}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                        { return ContentTypeJSON }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string                        { return ContentTypeMsgpack }
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, errors.Errorf("protobuf codec cannot marshal %T", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return errors.Errorf("protobuf codec cannot unmarshal into %T", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// codecFor returns the codec for the content type, ignoring any parameters
+// such as the charset. Messages without a content type are JSON.
+func codecFor(contentType string, codecs []Codec) (Codec, bool) {
+	if contentType == "" {
+		return JSONCodec, true
+	}
+	for _, c := range codecs {
+		if c.ContentType() == MediaType(contentType) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// MediaType returns the media type of contentType, without its parameters.
+func MediaType(contentType string) string {
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.TrimSpace(contentType)
+}
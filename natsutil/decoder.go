@@ -12,13 +12,15 @@ import (
 )
 
 /*
-	Caution: Do not cargo cult this code. This is meant for specific use case based on our understanding of the message payload possibilities.
-	DecodeNATSJSONEvent decodes the nats payload based on the msg subject -> request object map definition.
+Caution: Do not cargo cult this code. This is meant for specific use case based on our understanding of the message payload possibilities.
+DecodeNATSJSONEvent decodes the nats payload based on the msg subject -> request object map definition.
 
 	decoderMap := map[string]interface{}{
 		OrderCreatedTopic:     	order.CreatedEvent{},
 		OrderCancelledTopic:    order.CancelledEvent{},
 	}
+
+Use a Registry for wildcard subjects, versioned events and validation.
 */
 func DecodeNATSJSONEvent(decoderMap map[string]interface{}) natstransport.DecodeRequestFunc {
 	return func(_ context.Context, msg *nats.Msg) (request interface{}, err error) {
@@ -41,3 +43,83 @@ func DecodeNATSJSONEvent(decoderMap map[string]interface{}) natstransport.Decode
 	}
 }
 
+/*
+DecodeNATSEvent is DecodeNATSJSONEvent for mixed traffic: the payload codec is
+selected by the Content-Type header among codecs, which defaults to the
+built-in JSON, msgpack and protobuf codecs. CloudEvents in structured or
+binary mode are unwrapped and their data decoded with the codec of the
+datacontenttype attribute. Messages without a content type are JSON.
+
+Register protobuf messages as pointers, the decoded request is then a pointer too.
+Subjects registered with an untyped nil fail to decode with errors.Invalid.
+
+	decoderMap := map[string]interface{}{
+		OrderCreatedTopic:     	order.CreatedEvent{},
+		OrderShippedTopic:    	(*orderpb.ShippedEvent)(nil),
+	}
+*/
+func DecodeNATSEvent(decoderMap map[string]interface{}, codecs ...Codec) natstransport.DecodeRequestFunc {
+	if len(codecs) == 0 {
+		codecs = defaultCodecs
+	}
+	return func(_ context.Context, msg *nats.Msg) (request interface{}, err error) {
+		event, ok := decoderMap[msg.Subject]
+		if !ok {
+			return nil, errors.Errorf("decoder type for event %s undefined", msg.Subject)
+		}
+		if event == nil {
+			return nil, errors.New("decoder type for event "+msg.Subject+" is nil", errors.Invalid)
+		}
+
+		codec, data, err := payload(msg, codecs)
+		if err != nil {
+			return nil, err
+		}
+		return decodeEvent(reflect.TypeOf(event), codec, msg.Subject, data)
+	}
+}
+
+// payload returns the encoded data of msg along with its codec, unwrapping
+// CloudEvents.
+func payload(msg *nats.Msg, codecs []Codec) (Codec, []byte, error) {
+	contentType := msg.Header.Get(ContentTypeHdr)
+	ce, data, isCloudEvent, err := ReadCloudEvent(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if isCloudEvent {
+		contentType = ce.DataContentType
+	}
+	codec, ok := codecFor(contentType, codecs)
+	if !ok {
+		return nil, nil, errors.Errorf("codec for content type %s undefined for subject %s", contentType, msg.Subject)
+	}
+	return codec, data, nil
+}
+
+// decodeEvent decodes data into a new value of typ. Pointer types decode into
+// a pointer to a new element.
+func decodeEvent(typ reflect.Type, codec Codec, subject string, data []byte) (interface{}, error) {
+	if typ.Kind() == reflect.Ptr {
+		v := reflect.New(typ.Elem()).Interface()
+		if err := unmarshal(codec, subject, data, v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	v := reflect.New(typ)
+	if err := unmarshal(codec, subject, data, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+func unmarshal(codec Codec, subject string, data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if err := codec.Unmarshal(data, v); err != nil {
+		return errors.WithMessagef(err, "%s event decoding failed for subject %s", codec.ContentType(), subject)
+	}
+	return nil
+}
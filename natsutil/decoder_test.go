@@ -0,0 +1,104 @@
+package natsutil_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/etherlabsio/errors"
+	nats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/etherlabsio/pkg/natsutil"
+	pubsubnats "github.com/etherlabsio/pkg/pubsub/nats"
+)
+
+type orderCreated struct {
+	ID    string `json:"id" msgpack:"id"`
+	Total int    `json:"total" msgpack:"total"`
+}
+
+func TestDecodeNATSEvent(t *testing.T) {
+	decoderMap := map[string]interface{}{
+		"orders.created": orderCreated{},
+		"orders.note":    (*wrapperspb.StringValue)(nil),
+	}
+	dec := natsutil.DecodeNATSEvent(decoderMap)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		subject string
+		enc     func(ctx context.Context, msg *nats.Msg, request interface{}) error
+		request interface{}
+	}{
+		{"plain json", "orders.created", pubsubnats.EncodeJSONRequest, orderCreated{"1", 10}},
+		{"json", "orders.created", pubsubnats.EncodeRequest(natsutil.JSONCodec), orderCreated{"2", 20}},
+		{"msgpack", "orders.created", pubsubnats.EncodeRequest(natsutil.MsgpackCodec), orderCreated{"3", 30}},
+		{"protobuf", "orders.note", pubsubnats.EncodeRequest(natsutil.ProtobufCodec), wrapperspb.String("fragile")},
+		{"structured cloudevent", "orders.created", pubsubnats.EncodeCloudEventRequest("/orders", natsutil.JSONCodec, false), orderCreated{"4", 40}},
+		{"structured cloudevent with binary data", "orders.created", pubsubnats.EncodeCloudEventRequest("/orders", natsutil.MsgpackCodec, false), orderCreated{"5", 50}},
+		{"binary cloudevent", "orders.note", pubsubnats.EncodeCloudEventRequest("/orders", natsutil.ProtobufCodec, true), wrapperspb.String("gift")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &nats.Msg{Subject: tt.subject}
+			require.NoError(t, tt.enc(ctx, msg, tt.request))
+
+			got, err := dec(ctx, msg)
+			require.NoError(t, err)
+			if want, ok := tt.request.(*wrapperspb.StringValue); ok {
+				assert.Equal(t, want.GetValue(), got.(*wrapperspb.StringValue).GetValue())
+				return
+			}
+			assert.Equal(t, tt.request, got)
+		})
+	}
+}
+
+func TestDecodeNATSEvent_Errors(t *testing.T) {
+	dec := natsutil.DecodeNATSEvent(map[string]interface{}{"orders.created": orderCreated{}}, natsutil.JSONCodec)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		msg  *nats.Msg
+	}{
+		{"unknown subject", &nats.Msg{Subject: "orders.deleted", Data: []byte(`{}`)}},
+		{"unsupported content type", &nats.Msg{Subject: "orders.created", Header: nats.Header{natsutil.ContentTypeHdr: []string{natsutil.ContentTypeMsgpack}}, Data: []byte{0x80}}},
+		{"malformed payload", &nats.Msg{Subject: "orders.created", Data: []byte(`{`)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := dec(ctx, tt.msg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDecodeNATSEvent_NilType(t *testing.T) {
+	dec := natsutil.DecodeNATSEvent(map[string]interface{}{"orders.created": nil})
+
+	_, err := dec(context.Background(), &nats.Msg{Subject: "orders.created", Data: []byte(`{}`)})
+	assert.True(t, errors.IsKind(err, errors.Invalid), "got %v", err)
+}
+
+func TestReadCloudEvent(t *testing.T) {
+	for _, binary := range []bool{false, true} {
+		msg := &nats.Msg{Subject: "orders.created"}
+		ce := natsutil.CloudEvent{ID: "42", Source: "/orders", Type: "orders.created", DataContentType: natsutil.ContentTypeJSON}
+		require.NoError(t, natsutil.WriteCloudEvent(msg, ce, []byte(`{"id":"1"}`), binary))
+
+		got, data, ok, err := natsutil.ReadCloudEvent(msg)
+		require.NoError(t, err)
+		assert.True(t, ok)
+		ce.SpecVersion = natsutil.CloudEventsSpecVersion
+		assert.Equal(t, ce, got)
+		assert.JSONEq(t, `{"id":"1"}`, string(data))
+	}
+
+	_, _, ok, err := natsutil.ReadCloudEvent(&nats.Msg{Data: []byte(`{}`)})
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -0,0 +1,295 @@
+package natsutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/etherlabsio/errors"
+	natstransport "github.com/go-kit/kit/transport/nats"
+	nats "github.com/nats-io/nats.go"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// EventVersionHdr is the message header carrying the schema version of the
+// event.
+const EventVersionHdr = "Event-Version"
+
+// Registry maps subject patterns and schema versions to event types, so that
+// publishers and subscribers share a single definition of every event.
+//
+//	registry := natsutil.NewRegistry()
+//	registry.MustRegister("orders.created", order.CreatedEvent{}, natsutil.EventRequired("id"))
+//	registry.MustRegister("orders.*.shipped", order.ShippedEvent{}, natsutil.EventVersion("2"))
+//
+//	publisher := pubsubnats.NewPublisher(nc, pubsubnats.PublisherEncoder(registry.Encoder(natsutil.JSONCodec)))
+//	subscriber := pubsubnats.NewSubscriber(e, registry.Decoder())
+//
+// Literal subjects take precedence over wildcard patterns, which are matched in
+// the order they were registered.
+type Registry struct {
+	mu     sync.RWMutex
+	events []*eventType
+}
+
+type eventType struct {
+	pattern   string
+	version   string
+	typ       reflect.Type
+	required  []string
+	schema    *gojsonschema.Schema
+	validator func(interface{}) error
+}
+
+// EventOption sets an optional parameter for a registered event.
+type EventOption func(*eventType) error
+
+// EventVersion sets the schema version of the event, carried in the
+// Event-Version header. Events registered without a version match messages of
+// any version not registered explicitly.
+func EventVersion(version string) EventOption {
+	return func(e *eventType) error {
+		e.version = version
+		return nil
+	}
+}
+
+// EventRequired sets the top-level fields that must be present and non-null in
+// the JSON representation of the event.
+func EventRequired(fields ...string) EventOption {
+	return func(e *eventType) error {
+		e.required = append(e.required, fields...)
+		return nil
+	}
+}
+
+// EventSchema sets the JSON Schema the JSON representation of the event is
+// validated against.
+func EventSchema(schema string) EventOption {
+	return func(e *eventType) error {
+		s, err := gojsonschema.NewSchema(gojsonschema.NewStringLoader(schema))
+		if err != nil {
+			return errors.WithKindf(err, errors.Invalid, "invalid json schema for subject %s", e.pattern)
+		}
+		e.schema = s
+		return nil
+	}
+}
+
+// EventValidator sets a function validating the decoded event.
+func EventValidator(f func(event interface{}) error) EventOption {
+	return func(e *eventType) error {
+		e.validator = f
+		return nil
+	}
+}
+
+// NewRegistry returns an empty event Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register registers the type of event for the subject pattern. Register
+// pointers for events decoding into a pointer, such as protobuf messages.
+func (r *Registry) Register(pattern string, event interface{}, opts ...EventOption) error {
+	const op errors.Op = "natsutil.Registry.Register"
+	if !validPattern(pattern) {
+		return errors.New(fmt.Sprintf("invalid subject pattern %q", pattern), op, errors.Invalid)
+	}
+	if event == nil {
+		return errors.New("nil event for subject "+pattern, op, errors.Invalid)
+	}
+	e := &eventType{pattern: pattern, typ: reflect.TypeOf(event)}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return errors.WithOp(err, op)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.events {
+		if existing.pattern == e.pattern && existing.version == e.version {
+			return errors.New(fmt.Sprintf("event already registered for subject %s version %q", pattern, e.version), op, errors.AlreadyExist)
+		}
+	}
+	r.events = append(r.events, e)
+	return nil
+}
+
+// MustRegister is Register that panics on error.
+func (r *Registry) MustRegister(pattern string, event interface{}, opts ...EventOption) {
+	if err := r.Register(pattern, event, opts...); err != nil {
+		panic(err)
+	}
+}
+
+// lookup returns the event registered for subject and version. Explicit
+// versions are preferred over unversioned registrations.
+func (r *Registry) lookup(subject, version string) (*eventType, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var fallback *eventType
+	for _, literalOnly := range []bool{true, false} {
+		for _, e := range r.events {
+			if literal(e.pattern) != literalOnly || !MatchSubject(e.pattern, subject) {
+				continue
+			}
+			if e.version == version {
+				return e, true
+			}
+			if e.version == "" && fallback == nil {
+				fallback = e
+			}
+		}
+	}
+	return fallback, fallback != nil
+}
+
+// lookupType returns the event of type typ registered for subject.
+func (r *Registry) lookupType(subject string, typ reflect.Type) (*eventType, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var types []string
+	for _, literalOnly := range []bool{true, false} {
+		for _, e := range r.events {
+			if literal(e.pattern) != literalOnly || !MatchSubject(e.pattern, subject) {
+				continue
+			}
+			if e.typ == typ {
+				return e, nil
+			}
+			types = append(types, e.typ.String())
+		}
+	}
+	if len(types) == 0 {
+		return nil, errors.New("event for subject "+subject+" undefined", errors.Invalid)
+	}
+	return nil, errors.New(fmt.Sprintf("event for subject %s must be one of %s, got %s", subject, strings.Join(types, ", "), typ), errors.Invalid)
+}
+
+// Decoder returns a DecodeRequestFunc decoding messages into the event
+// registered for their subject and version, and validating them. The payload
+// codec is selected by the Content-Type header among codecs, which defaults to
+// the built-in JSON, msgpack and protobuf codecs. See DecodeNATSEvent.
+func (r *Registry) Decoder(codecs ...Codec) natstransport.DecodeRequestFunc {
+	if len(codecs) == 0 {
+		codecs = defaultCodecs
+	}
+	return func(_ context.Context, msg *nats.Msg) (interface{}, error) {
+		version := msg.Header.Get(EventVersionHdr)
+		e, ok := r.lookup(msg.Subject, version)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("event for subject %s version %q undefined", msg.Subject, version), errors.Invalid)
+		}
+		codec, data, err := payload(msg, codecs)
+		if err != nil {
+			return nil, errors.WithKind(err, errors.Invalid, "decode event")
+		}
+		event, err := decodeEvent(e.typ, codec, msg.Subject, data)
+		if err != nil {
+			return nil, errors.WithKind(err, errors.Invalid, "decode event")
+		}
+		if codec != JSONCodec {
+			data = nil
+		}
+		if err := e.validate(msg.Subject, event, data); err != nil {
+			return nil, err
+		}
+		return event, nil
+	}
+}
+
+// Encoder returns an EncodeRequestFunc serializing events with codec. The
+// request must be of a type registered for the subject, whose version is
+// recorded in the Event-Version header, and is validated before being encoded.
+func (r *Registry) Encoder(codec Codec) natstransport.EncodeRequestFunc {
+	return func(_ context.Context, msg *nats.Msg, request interface{}) error {
+		e, err := r.lookupType(msg.Subject, reflect.TypeOf(request))
+		if err != nil {
+			return err
+		}
+		b, err := codec.Marshal(request)
+		if err != nil {
+			return err
+		}
+		var data []byte
+		if codec == JSONCodec {
+			data = b
+		}
+		if err := e.validate(msg.Subject, request, data); err != nil {
+			return err
+		}
+
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(ContentTypeHdr, codec.ContentType())
+		if e.version != "" {
+			msg.Header.Set(EventVersionHdr, e.version)
+		}
+		msg.Data = b
+		return nil
+	}
+}
+
+// validate validates the event against its definition. data is the JSON
+// representation of the event if available, otherwise it is derived from
+// event.
+func (e *eventType) validate(subject string, event interface{}, data []byte) error {
+	if len(e.required) > 0 || e.schema != nil {
+		if data == nil {
+			b, err := json.Marshal(event)
+			if err != nil {
+				return errors.WithKindf(err, errors.Invalid, "event validation failed for subject %s", subject)
+			}
+			data = b
+		}
+		if err := e.validateJSON(subject, data); err != nil {
+			return err
+		}
+	}
+	if e.validator != nil {
+		if err := e.validator(event); err != nil {
+			return errors.WithKindf(err, errors.Invalid, "event validation failed for subject %s", subject)
+		}
+	}
+	return nil
+}
+
+func (e *eventType) validateJSON(subject string, data []byte) error {
+	if len(e.required) > 0 {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return errors.WithKindf(err, errors.Invalid, "event validation failed for subject %s", subject)
+		}
+		var missing []string
+		for _, f := range e.required {
+			if v, ok := fields[f]; !ok || string(v) == "null" {
+				missing = append(missing, f)
+			}
+		}
+		if len(missing) > 0 {
+			return errors.New(fmt.Sprintf("event for subject %s missing required fields: %s", subject, strings.Join(missing, ", ")), errors.Invalid)
+		}
+	}
+	if e.schema != nil {
+		result, err := e.schema.Validate(gojsonschema.NewBytesLoader(data))
+		if err != nil {
+			return errors.WithKindf(err, errors.Invalid, "event validation failed for subject %s", subject)
+		}
+		if !result.Valid() {
+			violations := make([]string, len(result.Errors()))
+			for i, v := range result.Errors() {
+				violations[i] = v.String()
+			}
+			return errors.New(fmt.Sprintf("event for subject %s violates schema: %s", subject, strings.Join(violations, "; ")), errors.Invalid)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package natsutil_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/etherlabsio/errors"
+	nats "github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/etherlabsio/pkg/natsutil"
+)
+
+type orderShipped struct {
+	ID      string `json:"id"`
+	Carrier string `json:"carrier,omitempty"`
+}
+
+type orderShippedV2 struct {
+	ID       string   `json:"id"`
+	Carriers []string `json:"carriers"`
+}
+
+func newRegistry(t *testing.T) *natsutil.Registry {
+	r := natsutil.NewRegistry()
+	require.NoError(t, r.Register("orders.created", orderCreated{}, natsutil.EventRequired("id")))
+	require.NoError(t, r.Register("orders.*.shipped", orderShipped{}, natsutil.EventSchema(`{
+		"type": "object",
+		"required": ["id"],
+		"properties": {"id": {"type": "string", "minLength": 1}}
+	}`)))
+	require.NoError(t, r.Register("orders.*.shipped", orderShippedV2{}, natsutil.EventVersion("2"),
+		natsutil.EventValidator(func(event interface{}) error {
+			if len(event.(orderShippedV2).Carriers) == 0 {
+				return errors.Str("no carriers")
+			}
+			return nil
+		})))
+	require.NoError(t, r.Register("audit.>", map[string]interface{}{}))
+	return r
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := newRegistry(t)
+
+	tests := []struct {
+		name    string
+		pattern string
+		event   interface{}
+		opts    []natsutil.EventOption
+		kind    errors.Kind
+	}{
+		{"duplicate", "orders.created", orderCreated{}, nil, errors.AlreadyExist},
+		{"duplicate version", "orders.*.shipped", orderShipped{}, []natsutil.EventOption{natsutil.EventVersion("2")}, errors.AlreadyExist},
+		{"empty token", "orders..created", orderCreated{}, nil, errors.Invalid},
+		{"inner full wildcard", "orders.>.created", orderCreated{}, nil, errors.Invalid},
+		{"nil event", "orders.deleted", nil, nil, errors.Invalid},
+		{"invalid schema", "orders.deleted", orderCreated{}, []natsutil.EventOption{natsutil.EventSchema(`{`)}, errors.Invalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := r.Register(tt.pattern, tt.event, tt.opts...)
+			require.Error(t, err)
+			assert.Equal(t, tt.kind, errors.KindOf(err))
+		})
+	}
+}
+
+func TestRegistry_RoundTrip(t *testing.T) {
+	r := newRegistry(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		subject string
+		codec   natsutil.Codec
+		event   interface{}
+		version string
+	}{
+		{"literal", "orders.created", natsutil.JSONCodec, orderCreated{"1", 10}, ""},
+		{"msgpack", "orders.created", natsutil.MsgpackCodec, orderCreated{"2", 20}, ""},
+		{"wildcard", "orders.eu.shipped", natsutil.JSONCodec, orderShipped{ID: "3"}, ""},
+		{"versioned", "orders.us.shipped", natsutil.JSONCodec, orderShippedV2{"4", []string{"ups"}}, "2"},
+		{"full wildcard", "audit.orders.created", natsutil.JSONCodec, map[string]interface{}{"by": "ops"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &nats.Msg{Subject: tt.subject}
+			require.NoError(t, r.Encoder(tt.codec)(ctx, msg, tt.event))
+			assert.Equal(t, tt.version, msg.Header.Get(natsutil.EventVersionHdr))
+
+			got, err := r.Decoder()(ctx, msg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.event, got)
+		})
+	}
+}
+
+func TestRegistry_Encoder_Invalid(t *testing.T) {
+	r := newRegistry(t)
+	enc := r.Encoder(natsutil.JSONCodec)
+
+	tests := []struct {
+		name    string
+		subject string
+		event   interface{}
+	}{
+		{"unregistered subject", "payments.created", orderCreated{"1", 10}},
+		{"wrong type", "orders.created", orderShipped{ID: "1"}},
+		{"pointer to registered type", "orders.created", &orderCreated{ID: "1"}},
+		{"schema violation", "orders.eu.shipped", orderShipped{}},
+		{"validator failure", "orders.eu.shipped", orderShippedV2{ID: "1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &nats.Msg{Subject: tt.subject}
+			err := enc(context.Background(), msg, tt.event)
+			require.Error(t, err)
+			assert.Equal(t, errors.Invalid, errors.KindOf(err))
+			assert.Nil(t, msg.Data)
+		})
+	}
+}
+
+func TestRegistry_Decoder_Invalid(t *testing.T) {
+	r := newRegistry(t)
+	dec := r.Decoder()
+
+	tests := []struct {
+		name string
+		msg  *nats.Msg
+	}{
+		{"unregistered subject", &nats.Msg{Subject: "payments.created", Data: []byte(`{}`)}},
+		{"missing required field", &nats.Msg{Subject: "orders.created", Data: []byte(`{"total": 10}`)}},
+		{"null required field", &nats.Msg{Subject: "orders.created", Data: []byte(`{"id": null}`)}},
+		{"schema violation", &nats.Msg{Subject: "orders.eu.shipped", Data: []byte(`{"id": ""}`)}},
+		{"malformed payload", &nats.Msg{Subject: "orders.created", Data: []byte(`{`)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := dec(context.Background(), tt.msg)
+			require.Error(t, err)
+			assert.Equal(t, errors.Invalid, errors.KindOf(err))
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package natsutil
+
+import "strings"
+
+// MatchSubject reports whether subject matches pattern, where a * token
+// matches any single token and a trailing > matches one or more tokens.
+func MatchSubject(pattern, subject string) bool {
+	pt := strings.Split(pattern, ".")
+	st := strings.Split(subject, ".")
+	for i, p := range pt {
+		if p == ">" && i == len(pt)-1 {
+			return len(st) > i
+		}
+		if i >= len(st) || (p != "*" && p != st[i]) {
+			return false
+		}
+	}
+	return len(pt) == len(st)
+}
+
+// validPattern reports whether pattern is a well formed subject pattern.
+func validPattern(pattern string) bool {
+	if pattern == "" {
+		return false
+	}
+	tokens := strings.Split(pattern, ".")
+	for i, t := range tokens {
+		switch {
+		case t == "":
+			return false
+		case t == ">" && i != len(tokens)-1:
+			return false
+		case t != "*" && t != ">" && strings.ContainsAny(t, "*> \t"):
+			return false
+		}
+	}
+	return true
+}
+
+func literal(pattern string) bool {
+	return !strings.ContainsAny(pattern, "*>")
+}
@@ -0,0 +1,178 @@
+// Package mem provides an in-process pubsub.Publisher and pubsub.Subscriber
+// bus for tests, so that event flows can be exercised without a NATS server.
+//
+// Subscribers are pubsub.Handler or pubsubnats.Handler implementations, served
+// synchronously from Publish with a nil *nats.Conn, so handlers replying over
+// the connection are not supported.
+package mem
+
+import (
+	"context"
+	"sync"
+
+	"github.com/etherlabsio/errors"
+	natstransport "github.com/go-kit/kit/transport/nats"
+	"github.com/nats-io/nats.go"
+
+	"github.com/etherlabsio/pkg/natsutil"
+	"github.com/etherlabsio/pkg/pubsub"
+	pubsubnats "github.com/etherlabsio/pkg/pubsub/nats"
+)
+
+var (
+	_ pubsub.Publisher    = (*Bus)(nil)
+	_ pubsub.Subscriber   = (*Bus)(nil)
+	_ pubsub.Subscription = (*Subscription)(nil)
+)
+
+// Bus is an in-memory message bus matching subjects like NATS, including the
+// * and > wildcards. It records every published message.
+type Bus struct {
+	enc    natstransport.EncodeRequestFunc
+	before []natstransport.RequestFunc
+
+	mu        sync.Mutex
+	subs      []*Subscription
+	published []*nats.Msg
+}
+
+// Option sets an optional parameter for the bus.
+type Option func(*Bus)
+
+// Encoder sets the encoder of published messages. It defaults to
+// pubsubnats.EncodeJSONRequest.
+func Encoder(enc natstransport.EncodeRequestFunc) Option {
+	return func(b *Bus) { b.enc = enc }
+}
+
+// Before sets the RequestFuncs applied to messages before they are
+// delivered, such as pubsubnats propagators.
+func Before(before ...natstransport.RequestFunc) Option {
+	return func(b *Bus) { b.before = append(b.before, before...) }
+}
+
+// NewBus returns an empty Bus.
+func NewBus(opts ...Option) *Bus {
+	b := &Bus{
+		enc: pubsubnats.EncodeJSONRequest,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Subscription is the interest of a handler in a subject.
+type Subscription struct {
+	bus     *Bus
+	subject string
+	handler func(msg *nats.Msg)
+}
+
+// Unsubscribe stops the delivery of messages to the subscription.
+func (s *Subscription) Unsubscribe() error {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	for i, sub := range s.bus.subs {
+		if sub == s {
+			s.bus.subs = append(s.bus.subs[:i], s.bus.subs[i+1:]...)
+			return nil
+		}
+	}
+	return nil
+}
+
+// Subscribe serves the messages published to subject, which may contain
+// wildcards, with h. Messages are handled with the values of ctx, as with
+// pubsubnats.MessageSubscriber.
+func (b *Bus) Subscribe(ctx context.Context, subject string, h pubsub.Handler) (pubsub.Subscription, error) {
+	return b.SubscribeHandler(subject, pubsubnats.NewHandler(h, pubsubnats.HandlerBaseContext(context.WithoutCancel(ctx)))), nil
+}
+
+// SubscribeHandler serves the messages published to subject, which may
+// contain wildcards, with the NATS handler h, such as a pubsubnats.Subscriber.
+func (b *Bus) SubscribeHandler(subject string, h pubsubnats.Handler) *Subscription {
+	sub := &Subscription{
+		bus:     b,
+		subject: subject,
+		handler: h.ServeMsg(nil),
+	}
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+	return sub
+}
+
+// Publish encodes msg and delivers it to the matching subscriptions before
+// returning.
+func (b *Bus) Publish(ctx context.Context, subject string, msg interface{}) error {
+	m := nats.NewMsg(subject)
+	if err := b.enc(ctx, m, msg); err != nil {
+		return errors.WithMessagef(err, "mem.Publish: encoder failure for topic %s", subject)
+	}
+	for _, f := range b.before {
+		ctx = f(ctx, m)
+	}
+	b.PublishMsg(m)
+	return nil
+}
+
+// PublishMsg delivers an already encoded message to the matching
+// subscriptions.
+func (b *Bus) PublishMsg(msg *nats.Msg) {
+	b.mu.Lock()
+	b.published = append(b.published, msg)
+	var handlers []func(msg *nats.Msg)
+	for _, sub := range b.subs {
+		if Match(sub.subject, msg.Subject) {
+			handlers = append(handlers, sub.handler)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, h := range handlers {
+		h(copyMsg(msg))
+	}
+}
+
+// Published returns the messages published so far, in order.
+func (b *Bus) Published() []*nats.Msg {
+	return b.PublishedTo(">")
+}
+
+// PublishedTo returns the messages published to subjects matching subject,
+// which may contain wildcards.
+func (b *Bus) PublishedTo(subject string) []*nats.Msg {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var msgs []*nats.Msg
+	for _, msg := range b.published {
+		if Match(subject, msg.Subject) {
+			msgs = append(msgs, msg)
+		}
+	}
+	return msgs
+}
+
+// Reset forgets the recorded messages. Subscriptions are kept.
+func (b *Bus) Reset() {
+	b.mu.Lock()
+	b.published = nil
+	b.mu.Unlock()
+}
+
+// Match reports whether subject matches pattern, where a * token matches any
+// single token and a trailing > matches one or more tokens.
+func Match(pattern, subject string) bool {
+	return natsutil.MatchSubject(pattern, subject)
+}
+
+func copyMsg(msg *nats.Msg) *nats.Msg {
+	m := nats.NewMsg(msg.Subject)
+	m.Reply = msg.Reply
+	for k, v := range msg.Header {
+		m.Header[k] = append([]string(nil), v...)
+	}
+	m.Data = append([]byte(nil), msg.Data...)
+	return m
+}
@@ -0,0 +1,97 @@
+package mem
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/etherlabsio/pkg/natsutil"
+	"github.com/etherlabsio/pkg/pubsub"
+	pubsubnats "github.com/etherlabsio/pkg/pubsub/nats"
+)
+
+func TestMatch(t *testing.T) {
+	var tests = []struct {
+		pattern, subject string
+		want             bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.created", "orders.cancelled", false},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders.created.v1", false},
+		{"*.created", "orders.created", true},
+		{"orders.>", "orders.created", true},
+		{"orders.>", "orders.created.v1", true},
+		{"orders.>", "orders", false},
+		{">", "orders", true},
+		{"orders", "orders.created", false},
+	}
+
+	for _, tt := range tests {
+		if have := Match(tt.pattern, tt.subject); have != tt.want {
+			t.Errorf("Match(%q, %q): have %v, want %v", tt.pattern, tt.subject, have, tt.want)
+		}
+	}
+}
+
+type createdEvent struct {
+	ID string `json:"id"`
+}
+
+func TestBus_DrivesSubscribers(t *testing.T) {
+	bus := NewBus(Before(func(ctx context.Context, msg *nats.Msg) context.Context {
+		msg.Header.Set(pubsubnats.CorrelationIDHdr, "req-1")
+		return ctx
+	}))
+
+	var received []createdEvent
+	var correlationIDs []string
+	e := func(ctx context.Context, request interface{}) (interface{}, error) {
+		received = append(received, request.(createdEvent))
+		id, _ := pubsubnats.CorrelationIDFromContext(ctx)
+		correlationIDs = append(correlationIDs, id)
+		return nil, nil
+	}
+	dec := natsutil.DecodeNATSJSONEvent(map[string]interface{}{"orders.created": createdEvent{}})
+	sub := bus.SubscribeHandler("orders.*", pubsubnats.NewSubscriber(e, dec, pubsubnats.SubscriberPropagators(pubsubnats.CorrelationID())))
+
+	ctx := context.Background()
+	require.NoError(t, bus.Publish(ctx, "orders.created", createdEvent{ID: "1"}))
+	require.NoError(t, bus.Publish(ctx, "payments.created", createdEvent{ID: "2"}))
+	sub.Unsubscribe()
+	require.NoError(t, bus.Publish(ctx, "orders.created", createdEvent{ID: "3"}))
+
+	assert.Equal(t, []createdEvent{{ID: "1"}}, received)
+	assert.Equal(t, []string{"req-1"}, correlationIDs)
+
+	require.Len(t, bus.Published(), 3)
+	orders := bus.PublishedTo("orders.>")
+	require.Len(t, orders, 2)
+	assert.JSONEq(t, `{"id": "3"}`, string(orders[1].Data))
+
+	bus.Reset()
+	assert.Empty(t, bus.Published())
+}
+
+func TestBus_Subscriber(t *testing.T) {
+	type key struct{}
+	var s pubsub.Subscriber = NewBus()
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "base"))
+
+	var received []string
+	sub, err := s.Subscribe(ctx, "orders.*", pubsub.HandlerFunc(func(ctx context.Context, msg pubsub.Message) error {
+		received = append(received, msg.Subject(), ctx.Value(key{}).(string))
+		return ctx.Err()
+	}))
+	require.NoError(t, err)
+	cancel()
+
+	require.NoError(t, s.(*Bus).Publish(context.Background(), "orders.created", createdEvent{ID: "1"}))
+	require.NoError(t, sub.Unsubscribe())
+	require.NoError(t, s.(*Bus).Publish(context.Background(), "orders.created", createdEvent{ID: "2"}))
+
+	assert.Equal(t, []string{"orders.created", "base"}, received)
+}
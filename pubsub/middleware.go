@@ -0,0 +1,79 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/etherlabsio/pkg/logutil"
+	"github.com/go-kit/kit/log"
+)
+
+// Middleware is a chainable decorator for message Handlers.
+type Middleware func(Handler) Handler
+
+// Chain is a helper function for composing middlewares. Messages will
+// traverse them in the order they're declared. That is, the first middleware
+// is treated as the outermost middleware.
+//
+// Chain is identical to the go-kit helper for Endpoint Middleware.
+func Chain(outer Middleware, others ...Middleware) Middleware {
+	return func(next Handler) Handler {
+		for i := len(others) - 1; i >= 0; i-- { // reverse
+			next = others[i](next)
+		}
+		return outer(next)
+	}
+}
+
+// Recovery converts panics of the handler into errors.Internal errors.
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg Message) (err error) {
+			defer func() {
+				if rvr := recover(); rvr != nil {
+					err = errors.New(fmt.Sprintf("panic serving subject %s: %+v", msg.Subject(), rvr), errors.Internal)
+				}
+			}()
+			return next.HandleMessage(ctx, msg)
+		})
+	}
+}
+
+// Logging logs the subject, duration and error of every handled message.
+func Logging(logger log.Logger) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg Message) (err error) {
+			defer func(begin time.Time) {
+				logutil.WithError(logger, err).Log(
+					"component", "messaging_subscriber",
+					"subject", msg.Subject(),
+					"took", time.Since(begin),
+				)
+			}(time.Now())
+			return next.HandleMessage(ctx, msg)
+		})
+	}
+}
+
+// Retry handles the message up to the given number of attempts for as long
+// as the handler fails with an error accepted by retryable, waiting for
+// backoff(retry) between attempts.
+func Retry(attempts int, backoff func(retry int) time.Duration, retryable func(error) bool) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg Message) error {
+			for attempt := 1; ; attempt++ {
+				err := next.HandleMessage(ctx, msg)
+				if err == nil || attempt >= attempts || !retryable(err) {
+					return err
+				}
+				select {
+				case <-ctx.Done():
+					return err
+				case <-time.After(backoff(attempt)):
+				}
+			}
+		})
+	}
+}
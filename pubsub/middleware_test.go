@@ -0,0 +1,81 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type testMessage struct {
+	subject string
+}
+
+func (m testMessage) Subject() string             { return m.subject }
+func (m testMessage) Data() []byte                { return nil }
+func (m testMessage) Header() map[string][]string { return nil }
+func (m testMessage) Ack() error                  { return nil }
+func (m testMessage) Nak() error                  { return nil }
+
+func ExampleChain() {
+	h := Chain(
+		annotate("one"),
+		annotate("two"),
+		annotate("three"),
+	)(HandlerFunc(func(ctx context.Context, msg Message) error { return nil }))
+
+	h.HandleMessage(context.Background(), testMessage{"orders.created"})
+
+	// Output:
+	// annotate:  one
+	// annotate:  two
+	// annotate:  three
+}
+
+func annotate(s string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, msg Message) error {
+			fmt.Println("annotate: ", s)
+			return next.HandleMessage(ctx, msg)
+		})
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	h := Recovery()(HandlerFunc(func(ctx context.Context, msg Message) error {
+		panic("something went wrong")
+	}))
+
+	err := h.HandleMessage(context.Background(), testMessage{"orders.created"})
+	assert.True(t, errors.IsKind(err, errors.Internal))
+	assert.Contains(t, err.Error(), "something went wrong")
+}
+
+func TestRetry(t *testing.T) {
+	var tests = []struct {
+		name  string
+		err   error
+		calls int
+	}{
+		{name: "retryable", err: errors.New("timeout", errors.IO), calls: 3},
+		{name: "permanent", err: errors.New("bad input", errors.Invalid), calls: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			h := Retry(3, func(int) time.Duration { return time.Millisecond }, func(err error) bool {
+				return errors.IsKind(err, errors.IO)
+			})(HandlerFunc(func(ctx context.Context, msg Message) error {
+				calls++
+				return tt.err
+			}))
+
+			assert.Equal(t, tt.err, h.HandleMessage(context.Background(), testMessage{"orders.created"}))
+			assert.Equal(t, tt.calls, calls)
+		})
+	}
+}
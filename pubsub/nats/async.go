@@ -0,0 +1,207 @@
+package pubsubnats
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log/level"
+	"github.com/nats-io/nats.go"
+)
+
+// PublisherAsync makes Publish enqueue messages into a buffer of the given
+// size, published in order by a background goroutine, instead of publishing
+// them inline. Publish blocks while the buffer is full, up to the publisher
+// timeout. Publish errors are reported by the next Flush or Close.
+func PublisherAsync(buffer int) PublisherOption {
+	return func(p *Publisher) { p.buffer = buffer }
+}
+
+// PublisherBatch sets how often asynchronous publishers flush the connection
+// and check for errors: after size messages or interval, whichever comes
+// first. It defaults to 64 messages or 100ms, which are kept for
+// non-positive values.
+func PublisherBatch(size int, interval time.Duration) PublisherOption {
+	return func(p *Publisher) {
+		if size > 0 {
+			p.batchSize = size
+		}
+		if interval > 0 {
+			p.batchInterval = interval
+		}
+	}
+}
+
+type asyncPublisher struct {
+	mu     sync.RWMutex
+	closed bool
+	queue  chan asyncMsg
+	done   chan struct{}
+
+	errMu sync.Mutex
+	err   error
+}
+
+// asyncMsg is either a message to publish or, if flushed is set, a flush
+// request.
+type asyncMsg struct {
+	ctx     context.Context
+	msg     *nats.Msg
+	flushed chan error
+}
+
+func newAsyncPublisher(buffer int) *asyncPublisher {
+	return &asyncPublisher{
+		queue: make(chan asyncMsg, buffer),
+		done:  make(chan struct{}),
+	}
+}
+
+// enqueue blocks until m is enqueued or ctx is done.
+func (a *asyncPublisher) enqueue(ctx context.Context, m asyncMsg) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return errors.New("publisher closed", errors.Invalid)
+	}
+	select {
+	case a.queue <- m:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close stops accepting messages and waits for the pending ones to be
+// published. It reports whether the publisher was open.
+func (a *asyncPublisher) close() bool {
+	a.mu.Lock()
+	closed := a.closed
+	if !closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	<-a.done
+	return !closed
+}
+
+func (a *asyncPublisher) fail(err error) {
+	if err == nil {
+		return
+	}
+	a.errMu.Lock()
+	if a.err == nil {
+		a.err = err
+	}
+	a.errMu.Unlock()
+}
+
+// reset returns and clears the first error since the last call.
+func (a *asyncPublisher) reset() error {
+	a.errMu.Lock()
+	defer a.errMu.Unlock()
+	err := a.err
+	a.err = nil
+	return err
+}
+
+// Flush waits until the messages published so far are received by the server,
+// up to the publisher timeout. For asynchronous publishers, it waits for the
+// buffered messages first and returns the first publish error since the last
+// Flush.
+func (p Publisher) Flush(ctx context.Context) error {
+	if p.async == nil {
+		return p.flush(ctx)
+	}
+	m := asyncMsg{ctx: ctx, flushed: make(chan error, 1)}
+	if err := p.async.enqueue(ctx, m); err != nil {
+		return err
+	}
+	select {
+	case err := <-m.flushed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close publishes the buffered messages of asynchronous publishers and flushes
+// the connection. Publishing after Close fails. The connection is left open.
+func (p Publisher) Close() error {
+	if p.async == nil {
+		return p.flush(context.Background())
+	}
+	if !p.async.close() {
+		return nil
+	}
+	return p.async.reset()
+}
+
+func (p Publisher) flush(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	if err := p.publisher.FlushWithContext(ctx); err != nil {
+		return err
+	}
+	return p.publisher.LastError()
+}
+
+// runAsync publishes the enqueued messages until the queue is closed.
+func (p Publisher) runAsync() {
+	a := p.async
+	defer close(a.done)
+
+	ticker := time.NewTicker(p.batchInterval)
+	defer ticker.Stop()
+
+	var unflushed int
+	flush := func(ctx context.Context) {
+		unflushed = 0
+		if err := p.flush(ctx); err != nil {
+			level.Error(p.logger).Log("status", "flush failed", "err", err)
+			a.fail(err)
+		}
+	}
+	for {
+		select {
+		case m, ok := <-a.queue:
+			if !ok {
+				flush(context.Background())
+				return
+			}
+			if m.flushed != nil {
+				flush(context.WithoutCancel(m.ctx))
+				m.flushed <- a.reset()
+				continue
+			}
+			p.publishAsync(m)
+			if unflushed++; unflushed >= p.batchSize {
+				flush(context.Background())
+			}
+		case <-ticker.C:
+			if unflushed > 0 {
+				flush(context.Background())
+			}
+		}
+	}
+}
+
+func (p Publisher) publishAsync(m asyncMsg) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(m.ctx), p.timeout)
+	defer cancel()
+
+	if err := p.publish(ctx, m.msg); err != nil {
+		level.Error(p.logger).Log(
+			"topic", m.msg.Subject,
+			"status", "failed",
+			"err", err,
+		)
+		p.async.fail(errors.WithMessagef(err, "publish failure for topic %s", m.msg.Subject))
+		return
+	}
+	for _, f := range p.after {
+		ctx = f(ctx, m.msg)
+	}
+}
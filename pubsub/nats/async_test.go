@@ -0,0 +1,94 @@
+package pubsubnats
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublisherAsync_Flush(t *testing.T) {
+	nc := runServer(t)
+	sub, err := nc.SubscribeSync("orders.created")
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	p := NewPublisher(nc, PublisherAsync(4), PublisherBatch(1000, time.Hour))
+	defer p.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, p.Publish(ctx, "orders.created", i))
+	}
+	require.NoError(t, p.Flush(ctx))
+
+	for i := 0; i < 10; i++ {
+		msg, err := sub.NextMsg(time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, strconv.Itoa(i), string(msg.Data))
+	}
+}
+
+func TestPublisherAsync_CloseDrainsPending(t *testing.T) {
+	nc := runServer(t)
+	sub, err := nc.SubscribeSync("orders.created")
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	p := NewPublisher(nc, PublisherAsync(100), PublisherBatch(1000, time.Hour))
+	ctx := context.Background()
+	for i := 0; i < 50; i++ {
+		require.NoError(t, p.Publish(ctx, "orders.created", i))
+	}
+	require.NoError(t, p.Close())
+	assert.NoError(t, p.Close(), "closing twice")
+
+	n, _, err := sub.Pending()
+	require.NoError(t, err)
+	for n < 50 {
+		time.Sleep(10 * time.Millisecond)
+		n, _, _ = sub.Pending()
+	}
+	assert.Equal(t, 50, n)
+	assert.Error(t, p.Publish(ctx, "orders.created", 50))
+}
+
+func TestPublisherAsync_ReportsErrors(t *testing.T) {
+	nc := runServer(t)
+	p := NewPublisher(nc, PublisherAsync(4), PublisherTimeout(100*time.Millisecond))
+	defer p.Close()
+
+	nc.Close()
+	require.NoError(t, p.Publish(context.Background(), "orders.created", 1))
+	assert.Error(t, p.Flush(context.Background()))
+}
+
+func TestPublisher_FlushSync(t *testing.T) {
+	nc := runServer(t)
+	p := NewPublisher(nc)
+	require.NoError(t, p.Publish(context.Background(), "orders.created", 1))
+	assert.NoError(t, p.Flush(context.Background()))
+	assert.NoError(t, p.Close())
+
+	nc.Close()
+	assert.Error(t, p.Flush(context.Background()))
+}
+
+func TestPublisherBatch_KeepsDefaultsForNonPositiveValues(t *testing.T) {
+	nc := runServer(t)
+	sub, err := nc.SubscribeSync("orders.created")
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	p := NewPublisher(nc, PublisherAsync(4), PublisherBatch(0, -time.Second))
+	defer p.Close()
+	assert.Equal(t, 64, p.batchSize)
+	assert.Equal(t, 100*time.Millisecond, p.batchInterval)
+
+	require.NoError(t, p.Publish(context.Background(), "orders.created", 1))
+	_, err = sub.NextMsg(time.Second)
+	assert.NoError(t, err)
+}
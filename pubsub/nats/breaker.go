@@ -0,0 +1,170 @@
+package pubsubnats
+
+import (
+	"context"
+	stderrors "errors"
+	"sync"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/nats-io/nats.go"
+)
+
+// defaultCoolDown is the default delay before an open breaker is probed.
+const defaultCoolDown = 5 * time.Second
+
+// PublisherSpool trips a circuit breaker after threshold consecutive publish
+// failures caused by connectivity problems or timeouts. Other failures, such
+// as oversized payloads, are returned to the caller and do not count. While
+// the breaker is open, messages are appended to spool instead of being
+// published, and Publish fails only if the spool is full.
+//
+// The spooled messages are replayed in order when the connection reconnects,
+// on Replay, or by a Publish call once the breaker has been open for the
+// cool-down set with PublisherSpoolCoolDown. The breaker closes once the spool
+// is empty.
+//
+// Messages left in spool by a previous process are replayed on the first
+// reconnect or Replay.
+func PublisherSpool(spool *Spool, threshold int) PublisherOption {
+	return func(p *Publisher) {
+		p.breaker = &breaker{spool: spool, threshold: threshold, coolDown: defaultCoolDown}
+	}
+}
+
+// PublisherSpoolCoolDown sets how long the breaker of PublisherSpool stays
+// open before a publish probes the connection by replaying the spool. It
+// defaults to 5 seconds.
+func PublisherSpoolCoolDown(d time.Duration) PublisherOption {
+	return func(p *Publisher) { p.spoolCoolDown = d }
+}
+
+type breaker struct {
+	mu        sync.Mutex
+	spool     *Spool
+	threshold int
+	coolDown  time.Duration
+	failures  int
+	open      bool
+	probeAt   time.Time
+}
+
+// connectivityError reports whether err is caused by the connection being
+// unavailable or too slow, as opposed to the message being rejected.
+func connectivityError(err error) bool {
+	for _, target := range []error{
+		nats.ErrConnectionClosed,
+		nats.ErrConnectionDraining,
+		nats.ErrConnectionReconnecting,
+		nats.ErrDisconnected,
+		nats.ErrNoServers,
+		nats.ErrReconnectBufExceeded,
+		nats.ErrStaleConnection,
+		nats.ErrTimeout,
+		context.DeadlineExceeded,
+	} {
+		if stderrors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// publish sends msg unless the breaker is open, and spools it if the breaker
+// is or gets open. Once the cool-down has elapsed, an open breaker is half
+// open: the spool is replayed before msg, and the breaker closes if it
+// succeeds.
+func (b *breaker) publish(ctx context.Context, msg *nats.Msg, send func(context.Context, *nats.Msg) error, logger log.Logger) error {
+	b.mu.Lock()
+	if b.open {
+		if time.Now().Before(b.probeAt) {
+			defer b.mu.Unlock()
+			return b.spool.Append(msg)
+		}
+		if err := b.replayLocked(ctx, send, logger); err != nil {
+			defer b.mu.Unlock()
+			b.probeAt = time.Now().Add(b.coolDown)
+			return b.spool.Append(msg)
+		}
+	}
+	b.mu.Unlock()
+
+	err := send(ctx, msg)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		return nil
+	}
+	if !connectivityError(err) {
+		return err
+	}
+	if b.failures++; !b.open && b.failures < b.threshold {
+		return err
+	}
+	if !b.open {
+		b.open = true
+		b.probeAt = time.Now().Add(b.coolDown)
+		level.Error(logger).Log("status", "circuit open", "failures", b.failures, "err", err)
+	}
+	if serr := b.spool.Append(msg); serr != nil {
+		return errors.WithMessagef(err, "spool failure: %v", serr)
+	}
+	return nil
+}
+
+// replay publishes the spooled messages, blocking publishes meanwhile to keep
+// them in order, and closes the breaker once the spool is empty.
+func (b *breaker) replay(ctx context.Context, send func(context.Context, *nats.Msg) error, logger log.Logger) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.replayLocked(ctx, send, logger)
+}
+
+// replayLocked is replay with b.mu held. Spooled messages failing for other
+// reasons than connectivity are dropped, so that they don't block the spool.
+func (b *breaker) replayLocked(ctx context.Context, send func(context.Context, *nats.Msg) error, logger log.Logger) error {
+	depth := b.spool.Len()
+	err := b.spool.Replay(func(msg *nats.Msg) error {
+		err := send(ctx, msg)
+		if err != nil && !connectivityError(err) {
+			level.Error(logger).Log("status", "spooled message dropped", "topic", msg.Subject, "err", err)
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		level.Error(logger).Log("status", "replay failed", "replayed", depth-b.spool.Len(), "pending", b.spool.Len(), "err", err)
+		return err
+	}
+	if b.open || depth > 0 {
+		level.Info(logger).Log("status", "circuit closed", "replayed", depth)
+	}
+	b.open = false
+	b.failures = 0
+	return nil
+}
+
+// Replay publishes the spooled messages in order, see PublisherSpool.
+func (p Publisher) Replay(ctx context.Context) error {
+	if p.breaker == nil {
+		return nil
+	}
+	return p.breaker.replay(ctx, p.send, p.logger)
+}
+
+// replayOnReconnect replays the spool when nc reconnects, chaining the
+// reconnect handler already set on the connection, such as the one of
+// WithDefaultConnectOptions.
+func (p Publisher) replayOnReconnect() {
+	next := p.publisher.ReconnectHandler()
+	p.publisher.SetReconnectHandler(func(c *nats.Conn) {
+		if next != nil {
+			next(c)
+		}
+		go p.Replay(context.Background())
+	})
+}
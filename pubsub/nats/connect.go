@@ -0,0 +1,214 @@
+package pubsubnats
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/nats-io/nats.go"
+
+	"github.com/etherlabsio/pkg/env"
+)
+
+// defaultCheckTimeout bounds Check when ctx has no deadline.
+const defaultCheckTimeout = 2 * time.Second
+
+// Config configures a NATS connection. Zero values keep the nats.go defaults,
+// except for MaxReconnects.
+type Config struct {
+	Name string
+	URLs []string
+
+	// TLS client certificate and CA files. TLS overrides them if set.
+	TLSCert string
+	TLSKey  string
+	TLSCA   string
+	TLS     *tls.Config
+
+	// CredsFile is a user JWT and NKey seed credentials file, NKeyFile an
+	// NKey seed file. Token and User/Password are alternatives to them.
+	CredsFile string
+	NKeyFile  string
+	Token     string
+	User      string
+	Password  string
+
+	ReconnectWait   time.Duration
+	ReconnectJitter time.Duration
+	PingInterval    time.Duration
+
+	// MaxReconnects is the number of reconnection attempts after the
+	// connection is lost. 0 disables reconnecting, and a negative value
+	// reconnects forever.
+	MaxReconnects int
+
+	Logger log.Logger
+}
+
+// ConfigFromEnv returns the Config set by the NATS_* environment variables:
+// NATS_NAME, NATS_URL (comma separated), NATS_TLS_CERT, NATS_TLS_KEY,
+// NATS_TLS_CA, NATS_CREDS, NATS_NKEY, NATS_TOKEN, NATS_USER, NATS_PASSWORD,
+// NATS_RECONNECT_WAIT, NATS_RECONNECT_JITTER, NATS_MAX_RECONNECTS and
+// NATS_PING_INTERVAL. NATS_MAX_RECONNECTS defaults to -1, reconnecting
+// forever.
+func ConfigFromEnv() Config {
+	var urls []string
+	for _, u := range strings.Split(env.String("NATS_URL", nats.DefaultURL), ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return Config{
+		Name:            env.String("NATS_NAME", ""),
+		URLs:            urls,
+		TLSCert:         env.String("NATS_TLS_CERT", ""),
+		TLSKey:          env.String("NATS_TLS_KEY", ""),
+		TLSCA:           env.String("NATS_TLS_CA", ""),
+		CredsFile:       env.String("NATS_CREDS", ""),
+		NKeyFile:        env.String("NATS_NKEY", ""),
+		Token:           env.String("NATS_TOKEN", ""),
+		User:            env.String("NATS_USER", ""),
+		Password:        env.String("NATS_PASSWORD", ""),
+		ReconnectWait:   env.Duration("NATS_RECONNECT_WAIT", 0),
+		ReconnectJitter: env.Duration("NATS_RECONNECT_JITTER", 0),
+		PingInterval:    env.Duration("NATS_PING_INTERVAL", 0),
+		MaxReconnects:   env.Int("NATS_MAX_RECONNECTS", -1),
+	}
+}
+
+// Conn is a NATS connection with a health check.
+type Conn struct {
+	*nats.Conn
+}
+
+// Connect connects to NATS as configured by cfg, on top of
+// WithDefaultConnectOptions. The connection attempt is bounded by ctx, whose
+// deadline is also the timeout of every server dialed. Asynchronous errors,
+// such as slow consumers, are logged.
+func Connect(ctx context.Context, cfg Config) (*Conn, error) {
+	const op errors.Op = "pubsubnats.Connect"
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithOp(err, op)
+	}
+	opts, err := cfg.options(ctx)
+	if err != nil {
+		return nil, errors.New("invalid nats config", err, op, errors.Invalid)
+	}
+	urls := cfg.URLs
+	if len(urls) == 0 {
+		urls = []string{nats.DefaultURL}
+	}
+	type result struct {
+		nc  *nats.Conn
+		err error
+	}
+	connected := make(chan result, 1)
+	go func() {
+		nc, err := nats.Connect(strings.Join(urls, ","), opts...)
+		connected <- result{nc, err}
+	}()
+	var nc *nats.Conn
+	select {
+	case r := <-connected:
+		if r.err != nil {
+			return nil, errors.New("connect to "+strings.Join(urls, ","), r.err, op, errors.IO)
+		}
+		nc = r.nc
+	case <-ctx.Done():
+		// the servers left to dial are bounded by the dial timeout
+		go func() {
+			if r := <-connected; r.nc != nil {
+				r.nc.Close()
+			}
+		}()
+		return nil, errors.New("connect to "+strings.Join(urls, ","), ctx.Err(), op, errors.IO)
+	}
+	return &Conn{nc}, nil
+}
+
+func (cfg Config) options(ctx context.Context) ([]nats.Option, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	opts := WithDefaultConnectOptions(cfg.Name, logger)
+	opts = append(opts, nats.ErrorHandler(newAsyncErrors(func(c *nats.Conn, sub *nats.Subscription, err error) {
+		keyvals := []interface{}{"natsclient", cfg.Name, "handler", "ErrorHandler", "err", err}
+		if sub != nil {
+			keyvals = append(keyvals, "subject", sub.Subject)
+			if err == nats.ErrSlowConsumer {
+				msgs, bytes, _ := sub.Pending()
+				dropped, _ := sub.Dropped()
+				keyvals = append(keyvals, "pending_msgs", msgs, "pending_bytes", bytes, "dropped", dropped)
+			}
+		}
+		level.Error(logger).Log(keyvals...)
+	}).handle))
+
+	if deadline, ok := ctx.Deadline(); ok {
+		opts = append(opts, nats.Timeout(time.Until(deadline)))
+	}
+
+	switch {
+	case cfg.TLS != nil:
+		opts = append(opts, nats.Secure(cfg.TLS))
+	case cfg.TLSCert != "" || cfg.TLSKey != "":
+		opts = append(opts, nats.ClientCert(cfg.TLSCert, cfg.TLSKey))
+	}
+	if cfg.TLS == nil && cfg.TLSCA != "" {
+		opts = append(opts, nats.RootCAs(cfg.TLSCA))
+	}
+
+	switch {
+	case cfg.CredsFile != "":
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	case cfg.NKeyFile != "":
+		opt, err := nats.NkeyOptionFromSeed(cfg.NKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	case cfg.Token != "":
+		opts = append(opts, nats.Token(cfg.Token))
+	case cfg.User != "":
+		opts = append(opts, nats.UserInfo(cfg.User, cfg.Password))
+	}
+
+	if cfg.ReconnectWait > 0 {
+		opts = append(opts, nats.ReconnectWait(cfg.ReconnectWait))
+	}
+	if cfg.ReconnectJitter > 0 {
+		opts = append(opts, nats.ReconnectJitter(cfg.ReconnectJitter, cfg.ReconnectJitter))
+	}
+	if cfg.PingInterval > 0 {
+		opts = append(opts, nats.PingInterval(cfg.PingInterval))
+	}
+	if cfg.MaxReconnects == 0 {
+		opts = append(opts, nats.NoReconnect())
+	} else {
+		opts = append(opts, nats.MaxReconnects(cfg.MaxReconnects))
+	}
+	return opts, nil
+}
+
+// Check is an implementation for our healthcheck endpoint to see if NATS is
+// connected and responding to a ping within the deadline of ctx.
+func (c *Conn) Check(ctx context.Context) error {
+	const op errors.Op = "pubsubnats.Check"
+	if !c.IsConnected() {
+		return errors.New("nats connection "+c.Status().String(), op, errors.IO)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultCheckTimeout)
+		defer cancel()
+	}
+	if err := c.FlushWithContext(ctx); err != nil {
+		return errors.New("nats ping", err, op, errors.IO)
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package pubsubnats
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/nats-io/jwt/v2"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnect(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  func(*server.Options)
+		cfg     Config
+		wantErr bool
+	}{
+		{"no auth", func(*server.Options) {}, Config{}, false},
+		{"token", func(o *server.Options) { o.Authorization = "s3cr3t" }, Config{Token: "s3cr3t"}, false},
+		{"wrong token", func(o *server.Options) { o.Authorization = "s3cr3t" }, Config{Token: "guess"}, true},
+		{"user", func(o *server.Options) { o.Username, o.Password = "app", "pw" }, Config{User: "app", Password: "pw"}, false},
+		{"missing nkey seed", func(*server.Options) {}, Config{NKeyFile: "testdata/missing.nk"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := natsserver.DefaultTestOptions
+			opts.Port = server.RANDOM_PORT
+			tt.server(&opts)
+			s := natsserver.RunServer(&opts)
+			defer s.Shutdown()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			cfg := tt.cfg
+			cfg.Name = "test"
+			cfg.URLs = []string{"nats://127.0.0.1:1", s.ClientURL()}
+			nc, err := Connect(ctx, cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer nc.Close()
+			assert.Equal(t, "test", nc.Opts.Name)
+			assert.NoError(t, nc.Check(ctx))
+			assert.True(t, dispatchesAsyncErrors(nc.ErrorHandler()), "subscriptions should reuse the error handler")
+		})
+	}
+}
+
+func TestConnect_BoundedByContext(t *testing.T) {
+	// servers accepting connections but never sending their INFO
+	var urls []string
+	for i := 0; i < 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer l.Close()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+		urls = append(urls, "nats://"+l.Addr().String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	_, err := Connect(ctx, Config{URLs: urls})
+	assert.True(t, errors.IsKind(err, errors.IO), "got %v", err)
+	assert.Less(t, time.Since(begin), 250*time.Millisecond)
+}
+
+func TestConnect_TLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", nil, nil)
+	writeCert(t, dir, "server", ca, caKey)
+	writeCert(t, dir, "client", ca, caKey)
+
+	tlsConfig, err := server.GenTLSConfig(&server.TLSConfigOpts{
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server-key.pem"),
+		CaFile:   filepath.Join(dir, "ca.pem"),
+		Verify:   true,
+	})
+	require.NoError(t, err)
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	opts.TLS, opts.TLSVerify, opts.TLSConfig = true, true, tlsConfig
+	s := natsserver.RunServer(&opts)
+	defer s.Shutdown()
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"client certificate", Config{
+			TLSCert: filepath.Join(dir, "client.pem"),
+			TLSKey:  filepath.Join(dir, "client-key.pem"),
+			TLSCA:   filepath.Join(dir, "ca.pem"),
+		}, false},
+		{"no client certificate", Config{TLSCA: filepath.Join(dir, "ca.pem")}, true},
+		{"unknown CA", Config{
+			TLSCert: filepath.Join(dir, "client.pem"),
+			TLSKey:  filepath.Join(dir, "client-key.pem"),
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			cfg := tt.cfg
+			cfg.URLs = []string{s.ClientURL()}
+			nc, err := Connect(ctx, cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer nc.Close()
+			assert.True(t, nc.TLSRequired())
+			assert.NoError(t, nc.Check(ctx))
+		})
+	}
+}
+
+// writeCert writes a certificate for 127.0.0.1 signed by parent, or a CA if
+// parent is nil, and its key to dir as name.pem and name-key.pem.
+func writeCert(t *testing.T, dir, name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0600))
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func TestConnect_CredsFile(t *testing.T) {
+	operator, err := nkeys.CreateOperator()
+	require.NoError(t, err)
+	account, err := nkeys.CreateAccount()
+	require.NoError(t, err)
+	user, err := nkeys.CreateUser()
+	require.NoError(t, err)
+	operatorPub, _ := operator.PublicKey()
+	accountPub, _ := account.PublicKey()
+	userPub, _ := user.PublicKey()
+
+	accountJWT, err := jwt.NewAccountClaims(accountPub).Encode(operator)
+	require.NoError(t, err)
+	userJWT, err := jwt.NewUserClaims(userPub).Encode(account)
+	require.NoError(t, err)
+	seed, _ := user.Seed()
+	creds, err := jwt.FormatUserConfig(userJWT, seed)
+	require.NoError(t, err)
+	credsFile := filepath.Join(t.TempDir(), "user.creds")
+	require.NoError(t, os.WriteFile(credsFile, creds, 0600))
+
+	resolver := &server.MemAccResolver{}
+	require.NoError(t, resolver.Store(accountPub, accountJWT))
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	opts.TrustedKeys = []string{operatorPub}
+	opts.AccountResolver = resolver
+	s := natsserver.RunServer(&opts)
+	defer s.Shutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	nc, err := Connect(ctx, Config{URLs: []string{s.ClientURL()}, CredsFile: credsFile})
+	require.NoError(t, err)
+	defer nc.Close()
+	assert.NoError(t, nc.Check(ctx))
+
+	_, err = Connect(ctx, Config{URLs: []string{s.ClientURL()}})
+	assert.Error(t, err, "connecting without credentials should fail")
+}
+
+func TestConfig_MaxReconnects(t *testing.T) {
+	tests := []struct {
+		name          string
+		maxReconnects int
+		wantAllow     bool
+		wantMax       int
+	}{
+		{"no reconnect", 0, false, 0},
+		{"bounded", 3, true, 3},
+		{"forever", -1, true, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nopts, err := Config{MaxReconnects: tt.maxReconnects}.options(context.Background())
+			require.NoError(t, err)
+			o := nats.GetDefaultOptions()
+			for _, opt := range nopts {
+				require.NoError(t, opt(&o))
+			}
+			assert.Equal(t, tt.wantAllow, o.AllowReconnect)
+			if tt.wantAllow {
+				assert.Equal(t, tt.wantMax, o.MaxReconnect)
+			}
+		})
+	}
+}
+
+func TestConn_Check_Disconnected(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	s := natsserver.RunServer(&opts)
+
+	nc, err := Connect(context.Background(), Config{URLs: []string{s.ClientURL()}})
+	require.NoError(t, err)
+	defer nc.Close()
+	require.NoError(t, nc.Check(context.Background()))
+
+	s.Shutdown()
+	require.Eventually(t, func() bool { return !nc.IsConnected() }, time.Second, time.Millisecond)
+	assert.Error(t, nc.Check(context.Background()))
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("NATS_URL", "nats://a:4222, nats://b:4222")
+	t.Setenv("NATS_TOKEN", "s3cr3t")
+	t.Setenv("NATS_RECONNECT_WAIT", "5s")
+	t.Setenv("NATS_MAX_RECONNECTS", "10")
+
+	cfg := ConfigFromEnv()
+	assert.Equal(t, []string{"nats://a:4222", "nats://b:4222"}, cfg.URLs)
+	assert.Equal(t, "s3cr3t", cfg.Token)
+	assert.Equal(t, 5*time.Second, cfg.ReconnectWait)
+	assert.Equal(t, 10, cfg.MaxReconnects)
+	assert.Zero(t, cfg.PingInterval)
+}
+
+func TestConfigFromEnv_Defaults(t *testing.T) {
+	cfg := ConfigFromEnv()
+	assert.Equal(t, []string{nats.DefaultURL}, cfg.URLs)
+	assert.Equal(t, -1, cfg.MaxReconnects, "reconnects forever by default")
+}
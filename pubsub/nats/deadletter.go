@@ -0,0 +1,37 @@
+package pubsubnats
+
+import (
+	"strconv"
+
+	"github.com/nats-io/nats.go"
+)
+
+// Headers added to messages republished to a dead-letter subject. The
+// original data and headers are preserved as is.
+const (
+	DeadLetterSubjectHdr    = "Dead-Letter-Subject"
+	DeadLetterErrorHdr      = "Dead-Letter-Error"
+	DeadLetterDeliveriesHdr = "Dead-Letter-Deliveries"
+	DeadLetterAttemptsHdr   = "Dead-Letter-Attempts"
+)
+
+// SubscriberDeadLetter republishes messages which could not be served to the
+// given subject along with the failure details in the Dead-Letter-* headers.
+// Messages are dead-lettered once all the endpoint attempts are exhausted or
+// the error is not retryable.
+func SubscriberDeadLetter(subject string) SubscriberOption {
+	return func(s *Subscriber) { s.deadLetter = subject }
+}
+
+func deadLetter(nc *nats.Conn, subject string, msg *nats.Msg, deliveries uint64, attempts int, cause error) error {
+	dl := nats.NewMsg(subject)
+	for k, v := range msg.Header {
+		dl.Header[k] = v
+	}
+	dl.Header.Set(DeadLetterSubjectHdr, msg.Subject)
+	dl.Header.Set(DeadLetterErrorHdr, cause.Error())
+	dl.Header.Set(DeadLetterDeliveriesHdr, strconv.FormatUint(deliveries, 10))
+	dl.Header.Set(DeadLetterAttemptsHdr, strconv.Itoa(attempts))
+	dl.Data = msg.Data
+	return nc.PublishMsg(dl)
+}
@@ -0,0 +1,241 @@
+package pubsubnats
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/nats-io/nats.go"
+)
+
+// DedupState is the processing state of a message ID.
+type DedupState int
+
+const (
+	// DedupReserved means the ID has been claimed by the caller.
+	DedupReserved DedupState = iota
+	// DedupPending means another delivery of the ID is being processed.
+	DedupPending
+	// DedupDone means the ID has been processed.
+	DedupDone
+)
+
+// DedupStore records the IDs of the messages being or having been processed.
+type DedupStore interface {
+	// Reserve claims id for processing. It returns DedupPending if id is
+	// already being processed and DedupDone if it has been processed.
+	Reserve(ctx context.Context, id string) (DedupState, error)
+	// Complete marks id as processed.
+	Complete(ctx context.Context, id string) error
+	// Release gives up the claim on id, so that it can be processed again.
+	Release(ctx context.Context, id string) error
+}
+
+type dedupConfig struct {
+	namespace string
+	ttl       time.Duration
+	lease     time.Duration
+}
+
+// DedupOption sets an optional parameter for dedup stores.
+type DedupOption func(*dedupConfig)
+
+// DedupNamespace sets the prefix of the keys in the store. It defaults to "dedup".
+func DedupNamespace(ns string) DedupOption {
+	return func(cfg *dedupConfig) { cfg.namespace = ns }
+}
+
+// DedupTTL sets how long processed IDs are remembered. It defaults to 24 hours
+// and should exceed the window in which duplicates are redelivered.
+func DedupTTL(ttl time.Duration) DedupOption {
+	return func(cfg *dedupConfig) { cfg.ttl = ttl }
+}
+
+// DedupLease sets how long an ID stays reserved without being completed or
+// released, e.g. when the consumer crashes. It defaults to 1 minute.
+func DedupLease(lease time.Duration) DedupOption {
+	return func(cfg *dedupConfig) { cfg.lease = lease }
+}
+
+func newDedupConfig(opts []DedupOption) dedupConfig {
+	cfg := dedupConfig{
+		namespace: "dedup",
+		ttl:       24 * time.Hour,
+		lease:     time.Minute,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+func (cfg dedupConfig) key(id string) string {
+	const separator = ":"
+	return cfg.namespace + separator + id
+}
+
+// MessageIDFunc extracts the unique ID of a message. An empty ID disables
+// deduplication for the message.
+type MessageIDFunc func(msg *nats.Msg) (string, error)
+
+// HeaderMessageID reads the message ID from the given header, such as
+// nats.MsgIdHdr which is set by JetStream publishers.
+func HeaderMessageID(header string) MessageIDFunc {
+	return func(msg *nats.Msg) (string, error) {
+		return msg.Header.Get(header), nil
+	}
+}
+
+// JSONFieldMessageID reads the message ID from a top level field of a JSON
+// payload.
+func JSONFieldMessageID(field string) MessageIDFunc {
+	return func(msg *nats.Msg) (string, error) {
+		var payload map[string]interface{}
+		if err := json.Unmarshal(msg.Data, &payload); err != nil {
+			return "", errors.WithMessagef(err, "message id field %s unreadable for subject %s", field, msg.Subject)
+		}
+		switch id := payload[field].(type) {
+		case nil:
+			return "", nil
+		case string:
+			return id, nil
+		default:
+			b, _ := json.Marshal(id)
+			return string(b), nil
+		}
+	}
+}
+
+// ContextWithMessageID returns a copy of ctx carrying the message ID used for
+// deduplication.
+func ContextWithMessageID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, messageIDKey, id)
+}
+
+// MessageIDFromContext returns the message ID carried by ctx, if any.
+func MessageIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(messageIDKey).(string)
+	return id, ok && id != ""
+}
+
+// Deduplicate returns an endpoint middleware which skips the requests whose
+// message ID, carried in the context, has already been processed. IDs are
+// only marked as processed once the endpoint succeeds. Requests whose ID is
+// being processed by another delivery fail with a retryable errors.IO error.
+//
+// With SubscriberJetStream, such a duplicate is nacked and redelivered after
+// the nak delay, so that it is processed should the other delivery fail.
+// Core NATS messages are not redelivered: the duplicate is only retried
+// within SubscriberRetry, and otherwise dead-lettered or dropped, relying on
+// the other delivery to succeed.
+func Deduplicate(store DedupStore) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			const op = errors.Op("pubsubnats.Deduplicate")
+			id, ok := MessageIDFromContext(ctx)
+			if !ok {
+				return next(ctx, request)
+			}
+
+			state, err := store.Reserve(ctx, id)
+			if err != nil {
+				return nil, errors.New("message id "+id+" reservation failed", err, op, errors.IO)
+			}
+			switch state {
+			case DedupDone:
+				return nil, nil
+			case DedupPending:
+				return nil, errors.New("message id "+id+" is being processed", op, errors.IO)
+			}
+
+			response, err := next(ctx, request)
+			if err != nil {
+				if rerr := store.Release(ctx, id); rerr != nil {
+					return nil, errors.WithMessagef(err, "message id %s release failed: %v", id, rerr)
+				}
+				return response, err
+			}
+			if err := store.Complete(ctx, id); err != nil {
+				return nil, errors.New("message id "+id+" completion failed", err, op, errors.IO)
+			}
+			return response, nil
+		}
+	}
+}
+
+// SubscriberDeduplicate skips messages which have already been processed
+// according to store, acknowledging them without invoking the endpoint.
+// Messages without an ID are always processed.
+func SubscriberDeduplicate(store DedupStore, id MessageIDFunc) SubscriberOption {
+	return func(s *Subscriber) {
+		s.before = append(s.before, func(ctx context.Context, msg *nats.Msg) context.Context {
+			v, err := id(msg)
+			if err != nil {
+				s.logger.Log("msg", "message id extraction failed", "subject", msg.Subject, "err", err)
+				return ctx
+			}
+			return ContextWithMessageID(ctx, v)
+		})
+		s.e = Deduplicate(store)(s.e)
+	}
+}
+
+// NewMemoryDedupStore returns a DedupStore keeping the IDs in memory. It is
+// only suitable for a single consumer process and for tests.
+func NewMemoryDedupStore(opts ...DedupOption) DedupStore {
+	return &memoryDedupStore{
+		cfg:     newDedupConfig(opts),
+		entries: map[string]memoryDedupEntry{},
+		now:     time.Now,
+	}
+}
+
+type memoryDedupEntry struct {
+	done    bool
+	expires time.Time
+}
+
+type memoryDedupStore struct {
+	cfg     dedupConfig
+	mu      sync.Mutex
+	entries map[string]memoryDedupEntry
+	now     func() time.Time
+}
+
+func (m *memoryDedupStore) Reserve(_ context.Context, id string) (DedupState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	key := m.cfg.key(id)
+	if e, ok := m.entries[key]; ok && now.Before(e.expires) {
+		if e.done {
+			return DedupDone, nil
+		}
+		return DedupPending, nil
+	}
+	m.entries[key] = memoryDedupEntry{expires: now.Add(m.cfg.lease)}
+	return DedupReserved, nil
+}
+
+func (m *memoryDedupStore) Complete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.entries[m.cfg.key(id)] = memoryDedupEntry{done: true, expires: now.Add(m.cfg.ttl)}
+	for k, e := range m.entries {
+		if !now.Before(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+	return nil
+}
+
+func (m *memoryDedupStore) Release(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, m.cfg.key(id))
+	return nil
+}
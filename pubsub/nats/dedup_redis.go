@@ -0,0 +1,58 @@
+package pubsubnats
+
+import (
+	"context"
+
+	goredis "github.com/go-redis/redis"
+
+	"github.com/etherlabsio/pkg/redis"
+)
+
+// NewRedisDedupStore returns a DedupStore keeping the IDs in Redis, so that
+// duplicates are detected across consumer processes.
+func NewRedisDedupStore(client *redis.Client, opts ...DedupOption) DedupStore {
+	return &redisDedupStore{
+		client: client,
+		cfg:    newDedupConfig(opts),
+	}
+}
+
+type redisDedupStore struct {
+	client *redis.Client
+	cfg    dedupConfig
+}
+
+const (
+	dedupPending = "pending"
+	dedupDone    = "done"
+)
+
+func (r *redisDedupStore) Reserve(_ context.Context, id string) (DedupState, error) {
+	key := r.cfg.key(id)
+	reserved, err := r.client.SetNX(key, dedupPending, r.cfg.lease).Result()
+	if err != nil {
+		return DedupPending, err
+	}
+	if reserved {
+		return DedupReserved, nil
+	}
+	v, err := r.client.Get(key).Result()
+	switch {
+	case err == goredis.Nil:
+		// The lease expired since SETNX, let the message be redelivered.
+		return DedupPending, nil
+	case err != nil:
+		return DedupPending, err
+	case v == dedupDone:
+		return DedupDone, nil
+	}
+	return DedupPending, nil
+}
+
+func (r *redisDedupStore) Complete(_ context.Context, id string) error {
+	return r.client.Set(r.cfg.key(id), dedupDone, r.cfg.ttl).Err()
+}
+
+func (r *redisDedupStore) Release(_ context.Context, id string) error {
+	return r.client.Del(r.cfg.key(id)).Err()
+}
@@ -0,0 +1,126 @@
+package pubsubnats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/etherlabsio/errors"
+	"github.com/etherlabsio/pkg/redis/redistest"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriberDeduplicate(t *testing.T) {
+	stores := map[string]DedupStore{
+		"memory": NewMemoryDedupStore(),
+		"redis":  NewRedisDedupStore(redistest.NewClient(t)),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			var processed []string
+			fail := true
+			e := func(_ context.Context, request interface{}) (interface{}, error) {
+				if fail {
+					fail = false
+					return nil, errors.Str("transient failure")
+				}
+				processed = append(processed, request.(string))
+				return nil, nil
+			}
+			dec := func(_ context.Context, msg *nats.Msg) (interface{}, error) {
+				return string(msg.Data), nil
+			}
+			serve := NewSubscriber(e, dec, SubscriberDeduplicate(store, HeaderMessageID(nats.MsgIdHdr))).ServeMsg(nil)
+
+			msg := func(id, data string) *nats.Msg {
+				m := nats.NewMsg("orders.created")
+				m.Header.Set(nats.MsgIdHdr, id)
+				m.Data = []byte(data)
+				return m
+			}
+			serve(msg("1", "first attempt fails"))
+			serve(msg("1", "redelivery"))
+			serve(msg("1", "duplicate"))
+			serve(msg("2", "second"))
+			serve(msg("", "without id"))
+			serve(msg("", "without id"))
+
+			assert.Equal(t, []string{"redelivery", "second", "without id", "without id"}, processed)
+		})
+	}
+}
+
+func TestSubscriberDeduplicate_FailureWhileDuplicateInFlight(t *testing.T) {
+	stores := map[string]DedupStore{
+		"memory": NewMemoryDedupStore(),
+		"redis":  NewRedisDedupStore(redistest.NewClient(t)),
+	}
+
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			var processed []string
+			e := func(_ context.Context, request interface{}) (interface{}, error) {
+				if request.(string) == "first attempt fails" {
+					close(started)
+					<-release
+					return nil, errors.Str("transient failure")
+				}
+				processed = append(processed, request.(string))
+				return nil, nil
+			}
+			dec := func(_ context.Context, msg *nats.Msg) (interface{}, error) {
+				return string(msg.Data), nil
+			}
+			errs := make(chan error, 3)
+			finalizer := func(_ context.Context, _ *nats.Msg, err error) { errs <- err }
+			serve := NewSubscriber(e, dec,
+				SubscriberDeduplicate(store, HeaderMessageID(nats.MsgIdHdr)),
+				SubscriberFinalizer(finalizer),
+			).ServeMsg(nil)
+
+			msg := func(data string) *nats.Msg {
+				m := nats.NewMsg("orders.created")
+				m.Header.Set(nats.MsgIdHdr, "1")
+				m.Data = []byte(data)
+				return m
+			}
+			go serve(msg("first attempt fails"))
+			<-started
+
+			serve(msg("duplicate in flight"))
+			err := <-errs
+			assert.True(t, errors.IsKind(err, errors.IO), "got %v", err)
+
+			close(release)
+			assert.Error(t, <-errs)
+
+			serve(msg("redelivery"))
+			assert.NoError(t, <-errs)
+			assert.Equal(t, []string{"redelivery"}, processed)
+		})
+	}
+}
+
+func TestJSONFieldMessageID(t *testing.T) {
+	var tests = []struct {
+		data string
+		want string
+	}{
+		{data: `{"id": "abc"}`, want: "abc"},
+		{data: `{"id": 42}`, want: "42"},
+		{data: `{"name": "no id"}`, want: ""},
+	}
+
+	for _, tt := range tests {
+		id, err := JSONFieldMessageID("id")(&nats.Msg{Data: []byte(tt.data)})
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, id)
+	}
+
+	_, err := JSONFieldMessageID("id")(&nats.Msg{Data: []byte("not json")})
+	assert.Error(t, err)
+}
@@ -0,0 +1,80 @@
+package pubsubnats
+
+import (
+	"context"
+	"time"
+
+	"github.com/etherlabsio/pkg/natsutil"
+	natstransport "github.com/go-kit/kit/transport/nats"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+)
+
+// EncodeRequest returns an EncodeRequestFunc serializing the request with
+// codec and recording its content type in the Content-Type header, so that
+// natsutil.DecodeNATSEvent can decode it.
+func EncodeRequest(codec natsutil.Codec) natstransport.EncodeRequestFunc {
+	return func(_ context.Context, msg *nats.Msg, request interface{}) error {
+		b, err := codec.Marshal(request)
+		if err != nil {
+			return err
+		}
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		msg.Header.Set(natsutil.ContentTypeHdr, codec.ContentType())
+		msg.Data = b
+		return nil
+	}
+}
+
+// EncodeCloudEventRequest returns an EncodeRequestFunc serializing the request
+// with codec as the data of a CloudEvent from source, typed after the subject.
+// In binary mode the event attributes are carried in the ce-* headers,
+// otherwise the event is written in structured JSON mode.
+func EncodeCloudEventRequest(source string, codec natsutil.Codec, binary bool) natstransport.EncodeRequestFunc {
+	enc := EncodeRequest(codec)
+	return func(ctx context.Context, msg *nats.Msg, request interface{}) error {
+		if err := enc(ctx, msg, request); err != nil {
+			return err
+		}
+		return wrapCloudEvent(msg, source, binary)
+	}
+}
+
+// PublisherCloudEvents wraps every published message in a structured mode
+// CloudEvent from source, typed after the subject. The datacontenttype is the
+// Content-Type set by the encoder, JSON by default. Messages already encoded
+// as CloudEvents, such as by EncodeCloudEventRequest, are left as is.
+func PublisherCloudEvents(source string) PublisherOption {
+	return func(p *Publisher) { p.cloudEventSource = source }
+}
+
+// wrapCloudEvent wraps the encoded payload of msg in a CloudEvent, unless it
+// is one already.
+func wrapCloudEvent(msg *nats.Msg, source string, binary bool) error {
+	if isCloudEvent(msg) {
+		return nil
+	}
+	contentType := msg.Header.Get(natsutil.ContentTypeHdr)
+	if contentType == "" {
+		contentType = natsutil.ContentTypeJSON
+	}
+	ce := natsutil.CloudEvent{
+		ID:              nuid.Next(),
+		Source:          source,
+		SpecVersion:     natsutil.CloudEventsSpecVersion,
+		Type:            msg.Subject,
+		Time:            time.Now().UTC(),
+		DataContentType: contentType,
+	}
+	return natsutil.WriteCloudEvent(msg, ce, msg.Data, binary)
+}
+
+// isCloudEvent reports whether msg is a binary or structured mode CloudEvent.
+func isCloudEvent(msg *nats.Msg) bool {
+	if msg.Header.Get(natsutil.CloudEventSpecVersionHdr) != "" {
+		return true
+	}
+	return natsutil.MediaType(msg.Header.Get(natsutil.ContentTypeHdr)) == natsutil.ContentTypeCloudEventsJSON
+}
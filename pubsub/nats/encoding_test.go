@@ -0,0 +1,103 @@
+package pubsubnats
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/etherlabsio/pkg/natsutil"
+)
+
+func TestPublisherCloudEvents(t *testing.T) {
+	nc := runServer(t)
+
+	type created struct {
+		ID string `json:"id"`
+	}
+	type result struct {
+		request interface{}
+		event   natsutil.CloudEvent
+	}
+	results := make(chan result, 1)
+	e := func(ctx context.Context, request interface{}) (interface{}, error) {
+		ce, _ := natsutil.CloudEventFromContext(ctx)
+		results <- result{request, ce}
+		return nil, nil
+	}
+	dec := natsutil.DecodeNATSEvent(map[string]interface{}{"orders.created": created{}})
+
+	raw, err := nc.SubscribeSync("orders.created")
+	require.NoError(t, err)
+	defer raw.Unsubscribe()
+
+	h := NewSubscriber(e, dec, SubscriberBefore(natsutil.CloudEventToContext()))
+	sub, err := nc.Subscribe("orders.created", h.ServeMsg(nc))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	p := NewPublisher(nc, PublisherCloudEvents("/orders"))
+	require.NoError(t, p.Publish(context.Background(), "orders.created", created{"1"}))
+
+	select {
+	case r := <-results:
+		assert.Equal(t, created{"1"}, r.request)
+		assert.NotEmpty(t, r.event.ID)
+		assert.Equal(t, "/orders", r.event.Source)
+		assert.Equal(t, "orders.created", r.event.Type)
+		assert.Equal(t, natsutil.CloudEventsSpecVersion, r.event.SpecVersion)
+		assert.Equal(t, natsutil.ContentTypeJSON, r.event.DataContentType)
+		assert.False(t, r.event.Time.IsZero())
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	msg, err := raw.NextMsg(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, natsutil.ContentTypeCloudEventsJSON, msg.Header.Get(natsutil.ContentTypeHdr))
+	var envelope map[string]interface{}
+	require.NoError(t, json.Unmarshal(msg.Data, &envelope))
+	assert.Equal(t, map[string]interface{}{"id": "1"}, envelope["data"])
+}
+
+func TestPublisherCloudEvents_SkipsCloudEvents(t *testing.T) {
+	nc := runServer(t)
+	raw, err := nc.SubscribeSync("orders.>")
+	require.NoError(t, err)
+	defer raw.Unsubscribe()
+
+	for _, binary := range []bool{true, false} {
+		p := NewPublisher(nc,
+			PublisherEncoder(EncodeCloudEventRequest("/encoder", natsutil.JSONCodec, binary)),
+			PublisherCloudEvents("/orders"),
+		)
+		require.NoError(t, p.Publish(context.Background(), "orders.created", map[string]string{"id": "1"}))
+
+		msg, err := raw.NextMsg(time.Second)
+		require.NoError(t, err)
+		ce, data, ok, err := natsutil.ReadCloudEvent(msg)
+		require.NoError(t, err)
+		require.True(t, ok)
+		assert.Equal(t, "/encoder", ce.Source)
+		assert.JSONEq(t, `{"id": "1"}`, string(data), "binary: %v", binary)
+	}
+}
+
+func TestEncodeCloudEventRequest_Binary(t *testing.T) {
+	msg := &nats.Msg{Subject: "orders.created"}
+	enc := EncodeCloudEventRequest("/orders", natsutil.MsgpackCodec, true)
+	require.NoError(t, enc(context.Background(), msg, map[string]string{"id": "1"}))
+
+	assert.Equal(t, "/orders", msg.Header.Get(natsutil.CloudEventSourceHdr))
+	assert.Equal(t, "orders.created", msg.Header.Get(natsutil.CloudEventTypeHdr))
+	assert.Equal(t, natsutil.ContentTypeMsgpack, msg.Header.Get(natsutil.ContentTypeHdr))
+
+	ctx := natsutil.CloudEventToContext()(context.Background(), msg)
+	ce, ok := natsutil.CloudEventFromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, msg.Header.Get(natsutil.CloudEventIDHdr), ce.ID)
+}
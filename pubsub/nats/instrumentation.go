@@ -0,0 +1,157 @@
+package pubsubnats
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/nats-io/nats.go"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the tracer of this package.
+const instrumentationName = "github.com/etherlabsio/pkg/pubsub/nats"
+
+// Metrics are the instruments recorded for published or consumed messages,
+// labelled by "subject". Nil instruments are not recorded.
+type Metrics struct {
+	// Messages counts the published or consumed messages.
+	Messages metrics.Counter
+	// Errors counts the failed publishes or message handlings.
+	Errors metrics.Counter
+	// Bytes observes the payload sizes.
+	Bytes metrics.Histogram
+	// Duration observes the publish or handler latency in seconds.
+	Duration metrics.Histogram
+}
+
+func (m Metrics) observe(subject string, size int, took time.Duration, err error) {
+	if m.Messages != nil {
+		m.Messages.With("subject", subject).Add(1)
+	}
+	if m.Errors != nil && err != nil {
+		m.Errors.With("subject", subject).Add(1)
+	}
+	if m.Bytes != nil {
+		m.Bytes.With("subject", subject).Observe(float64(size))
+	}
+	if m.Duration != nil {
+		m.Duration.With("subject", subject).Observe(took.Seconds())
+	}
+}
+
+// PublisherMetrics records the published messages in m.
+func PublisherMetrics(m Metrics) PublisherOption {
+	return func(p *Publisher) { p.metrics = &m }
+}
+
+// PublisherTracing creates a producer span from tp for every published
+// message, and injects its context in the message headers with the global
+// OpenTelemetry propagator, so that consumer spans are linked to it.
+func PublisherTracing(tp trace.TracerProvider) PublisherOption {
+	return func(p *Publisher) { p.tracer = tp.Tracer(instrumentationName) }
+}
+
+// instrument calls publish recording the configured metrics and span.
+func (p Publisher) instrument(ctx context.Context, msg *nats.Msg, publish func(context.Context, *nats.Msg) error) error {
+	var span trace.Span
+	if p.tracer != nil {
+		ctx, span = p.tracer.Start(ctx, msg.Subject+" publish",
+			trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(spanAttributes(msg)...),
+		)
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		otel.GetTextMapPropagator().Inject(ctx, headerCarrier(msg.Header))
+	}
+
+	begin := time.Now()
+	err := publish(ctx, msg)
+
+	if p.metrics != nil {
+		p.metrics.observe(msg.Subject, len(msg.Data), time.Since(begin), err)
+	}
+	if span != nil {
+		endSpan(span, err)
+	}
+	return err
+}
+
+// SubscriberMetrics records the consumed messages in m. Duration observes the
+// time from the message being received to being settled. Messages dropped by
+// a middleware without error, such as FilterSubjects, are not recorded.
+func SubscriberMetrics(m Metrics) SubscriberOption {
+	return func(s *Subscriber) {
+		s.before = append(s.before, func(ctx context.Context, _ *nats.Msg) context.Context {
+			return context.WithValue(ctx, consumedKey, true)
+		})
+		s.finalizer = append(s.finalizer, func(ctx context.Context, msg *nats.Msg, err error) {
+			consumed, _ := ctx.Value(consumedKey).(bool)
+			begin, ok := ctx.Value(receivedAtKey).(time.Time)
+			if !ok || !consumed && err == nil {
+				return
+			}
+			m.observe(msg.Subject, len(msg.Data), time.Since(begin), err)
+		})
+	}
+}
+
+// SubscriberTracing creates a consumer span from tp for every consumed message,
+// child of the producer span propagated in the message headers with the global
+// OpenTelemetry propagator. The span is available to the endpoint through
+// trace.SpanFromContext.
+func SubscriberTracing(tp trace.TracerProvider) SubscriberOption {
+	tracer := tp.Tracer(instrumentationName)
+	return func(s *Subscriber) {
+		s.before = append(s.before, func(ctx context.Context, msg *nats.Msg) context.Context {
+			ctx = otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Header))
+			ctx, _ = tracer.Start(ctx, msg.Subject+" receive",
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(spanAttributes(msg)...),
+			)
+			return ctx
+		})
+		s.finalizer = append(s.finalizer, func(ctx context.Context, _ *nats.Msg, err error) {
+			endSpan(trace.SpanFromContext(ctx), err)
+		})
+	}
+}
+
+func spanAttributes(msg *nats.Msg) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("messaging.system", "nats"),
+		attribute.String("messaging.destination.name", msg.Subject),
+		attribute.Int("messaging.message.body.size", len(msg.Data)),
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// headerCarrier adapts the case-sensitive nats.Header to the OpenTelemetry
+// propagation.TextMapCarrier.
+type headerCarrier nats.Header
+
+var _ propagation.TextMapCarrier = headerCarrier(nil)
+
+func (h headerCarrier) Get(key string) string { return nats.Header(h).Get(key) }
+
+func (h headerCarrier) Set(key, value string) { nats.Header(h).Set(key, value) }
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
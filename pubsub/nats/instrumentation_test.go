@@ -0,0 +1,203 @@
+package pubsubnats
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// counters keeps the generic counters derived by With, which do not share
+// their value with the counter they derive from.
+type counters struct {
+	*generic.Counter
+	mu      sync.Mutex
+	derived map[string]*generic.Counter
+}
+
+func newCounters(name string) *counters {
+	return &counters{Counter: generic.NewCounter(name), derived: map[string]*generic.Counter{}}
+}
+
+func (c *counters) With(lvs ...string) metrics.Counter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := strings.Join(lvs, ",")
+	if _, ok := c.derived[key]; !ok {
+		c.derived[key] = c.Counter.With(lvs...).(*generic.Counter)
+	}
+	return c.derived[key]
+}
+
+func (c *counters) value(lvs ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d, ok := c.derived[strings.Join(lvs, ",")]; ok {
+		return d.Value()
+	}
+	return 0
+}
+
+// histograms keeps the generic histograms derived by With, for the same
+// reason.
+type histograms struct {
+	*generic.SimpleHistogram
+	mu      sync.Mutex
+	derived map[string]*generic.SimpleHistogram
+}
+
+func newHistograms() *histograms {
+	return &histograms{SimpleHistogram: generic.NewSimpleHistogram(), derived: map[string]*generic.SimpleHistogram{}}
+}
+
+func (h *histograms) With(lvs ...string) metrics.Histogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(lvs, ",")
+	if _, ok := h.derived[key]; !ok {
+		h.derived[key] = h.SimpleHistogram.With(lvs...).(*generic.SimpleHistogram)
+	}
+	return h.derived[key]
+}
+
+func (h *histograms) average(lvs ...string) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if d, ok := h.derived[strings.Join(lvs, ",")]; ok {
+		return d.ApproximateMovingAverage()
+	}
+	return 0
+}
+
+func TestInstrumentation_PublishToSubscriber(t *testing.T) {
+	nc := runServer(t)
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	pubMessages, pubBytes := newCounters("messages"), newHistograms()
+	subMessages, subErrors, subDuration := newCounters("messages"), newCounters("errors"), newHistograms()
+	pubMetrics := Metrics{Messages: pubMessages, Bytes: pubBytes}
+	subMetrics := Metrics{Messages: subMessages, Errors: subErrors, Duration: subDuration}
+
+	handled := make(chan trace.SpanContext, 2)
+	e := func(ctx context.Context, request interface{}) (interface{}, error) {
+		handled <- trace.SpanContextFromContext(ctx)
+		if request.(string) == "bad" {
+			return nil, errors.New("rejected", errors.Invalid)
+		}
+		return nil, nil
+	}
+	dec := func(_ context.Context, msg *nats.Msg) (interface{}, error) { return string(msg.Data), nil }
+	h := NewSubscriber(e, dec, SubscriberMetrics(subMetrics), SubscriberTracing(tp))
+	sub, err := nc.Subscribe("orders.created", h.ServeMsg(nc))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	p := NewPublisher(nc,
+		PublisherEncoder(func(_ context.Context, msg *nats.Msg, request interface{}) error {
+			msg.Data = []byte(request.(string))
+			return nil
+		}),
+		PublisherMetrics(pubMetrics),
+		PublisherTracing(tp),
+	)
+	ctx := context.Background()
+	require.NoError(t, p.Publish(ctx, "orders.created", "good"))
+	require.NoError(t, p.Publish(ctx, "orders.created", "bad"))
+
+	for i := 0; i < 2; i++ {
+		select {
+		case sc := <-handled:
+			assert.True(t, sc.IsValid())
+		case <-time.After(time.Second):
+			t.Fatal("message not received")
+		}
+	}
+	require.Eventually(t, func() bool { return len(spans.Ended()) == 4 }, time.Second, time.Millisecond)
+
+	assert.Equal(t, 2.0, pubMessages.value("subject", "orders.created"))
+	assert.Equal(t, 3.5, pubBytes.average("subject", "orders.created"))
+	assert.Equal(t, 2.0, subMessages.value("subject", "orders.created"))
+	assert.Equal(t, 1.0, subErrors.value("subject", "orders.created"))
+	assert.Positive(t, subDuration.average("subject", "orders.created"))
+
+	producers := map[trace.SpanID]sdktrace.ReadOnlySpan{}
+	var consumers []sdktrace.ReadOnlySpan
+	for _, s := range spans.Ended() {
+		switch s.SpanKind() {
+		case trace.SpanKindProducer:
+			producers[s.SpanContext().SpanID()] = s
+		case trace.SpanKindConsumer:
+			consumers = append(consumers, s)
+		}
+	}
+	require.Len(t, producers, 2)
+	require.Len(t, consumers, 2)
+	var failed int
+	for _, c := range consumers {
+		producer, ok := producers[c.Parent().SpanID()]
+		require.True(t, ok, "consumer span is a child of a producer span")
+		assert.Equal(t, producer.SpanContext().TraceID(), c.SpanContext().TraceID())
+		if c.Status().Code == codes.Error {
+			failed++
+		}
+	}
+	assert.Equal(t, 1, failed)
+}
+
+func TestSubscriberMetrics_FilteredMessage(t *testing.T) {
+	messages, duration := newCounters("messages"), newHistograms()
+	h := NewSubscriber(endpoint.Nop, NopRequestDecoder,
+		SubscriberMiddleware(FilterSubjects("orders.created")),
+		SubscriberMetrics(Metrics{Messages: messages, Duration: duration}),
+	)
+
+	h.ServeMsg(nil)(&nats.Msg{Subject: "orders.deleted"})
+	assert.Zero(t, messages.value("subject", "orders.deleted"))
+
+	h.ServeMsg(nil)(&nats.Msg{Subject: "orders.created"})
+	assert.Equal(t, 1.0, messages.value("subject", "orders.created"))
+	assert.Less(t, duration.average("subject", "orders.created"), 1.0)
+}
+
+func TestPublisherTracing_WithW3CTraceContext(t *testing.T) {
+	nc := runServer(t)
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	spans := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+
+	sub, err := nc.SubscribeSync("orders.created")
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	p := NewPublisher(nc, PublisherPropagators(W3CTraceContext()), PublisherTracing(tp))
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "parent")
+	require.NoError(t, p.Publish(ctx, "orders.created", "payload"))
+	parent.End()
+
+	msg, err := sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	require.Len(t, spans.Ended(), 2)
+	producer := spans.Ended()[0].SpanContext()
+	assert.Equal(t, "00-"+producer.TraceID().String()+"-"+producer.SpanID().String()+"-01", msg.Header.Get(TraceParentHdr))
+}
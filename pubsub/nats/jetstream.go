@@ -0,0 +1,116 @@
+package pubsubnats
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats.go"
+)
+
+// pullMaxWait bounds a single fetch request of a pull consumer.
+const pullMaxWait = time.Second
+
+// SubscriberJetStream makes the subscriber settle every JetStream message
+// explicitly: it is acked once the endpoint succeeds, nacked with the
+// configured delay when the endpoint fails and terminated when it cannot be
+// decoded, fails with an error which is not retryable or has reached the
+// maximum number of deliveries. Terminated messages
+// are published to the dead-letter subject, if one is set.
+func SubscriberJetStream() SubscriberOption {
+	return func(s *Subscriber) { s.jetstream = true }
+}
+
+// SubscriberNakDelay sets the delay after which a failed JetStream message is
+// redelivered. By default it is redelivered immediately.
+func SubscriberNakDelay(delay time.Duration) SubscriberOption {
+	return func(s *Subscriber) { s.nakDelay = delay }
+}
+
+// SubscriberMaxDeliver sets the number of deliveries after which a failing
+// JetStream message is given up on. It should match the MaxDeliver of the
+// consumer. By default messages are redelivered until they succeed.
+func SubscriberMaxDeliver(n int) SubscriberOption {
+	return func(s *Subscriber) { s.maxDeliver = n }
+}
+
+// settle acks, naks or terminates msg depending on the outcome of serving it.
+// Errors are terminal when retrying the message cannot succeed.
+func (s Subscriber) settle(nc *nats.Conn, msg *nats.Msg, err error, terminal bool, attempts int, logger log.Logger) {
+	if serr := s.doSettle(nc, msg, err, terminal, attempts); serr != nil {
+		logger.Log(
+			"msg", "error settling nats msg",
+			"err", serr,
+		)
+	}
+}
+
+func (s Subscriber) doSettle(nc *nats.Conn, msg *nats.Msg, err error, terminal bool, attempts int) error {
+	if err == nil {
+		if s.jetstream {
+			return msg.Ack()
+		}
+		return nil
+	}
+
+	var deliveries uint64 = 1
+	if s.jetstream {
+		if md, mdErr := msg.Metadata(); mdErr == nil {
+			deliveries = md.NumDelivered
+		}
+		exhausted := s.maxDeliver > 0 && deliveries >= uint64(s.maxDeliver)
+		if !terminal && !exhausted {
+			return msg.NakWithDelay(s.nakDelay)
+		}
+	}
+
+	if s.deadLetter != "" {
+		if dlErr := deadLetter(nc, s.deadLetter, msg, deliveries, attempts, err); dlErr != nil {
+			if s.jetstream {
+				// keep the message in the stream rather than losing it
+				msg.NakWithDelay(s.nakDelay)
+			}
+			return dlErr
+		}
+	}
+
+	if s.jetstream {
+		return msg.Term()
+	}
+	return nil
+}
+
+// SubscribeJetStream returns a SubscriberFunc creating a durable push consumer
+// for subject which serves messages with h. Messages must be acknowledged
+// explicitly, so h is expected to be a Subscriber with SubscriberJetStream set.
+func SubscribeJetStream(nc *nats.Conn, js nats.JetStreamContext, subject, durable string, h Handler, opts ...nats.SubOpt) SubscriberFunc {
+	return func() (*nats.Subscription, error) {
+		opts := append([]nats.SubOpt{nats.Durable(durable), nats.ManualAck()}, opts...)
+		return js.Subscribe(subject, h.ServeMsg(nc), opts...)
+	}
+}
+
+// PullSubscribeJetStream returns a SubscriberFunc creating a durable pull
+// consumer for subject. Messages are fetched in batches of the given size and
+// served one at a time with h until the subscription is closed.
+func PullSubscribeJetStream(nc *nats.Conn, js nats.JetStreamContext, subject, durable string, h Handler, batch int, opts ...nats.SubOpt) SubscriberFunc {
+	return func() (*nats.Subscription, error) {
+		sub, err := js.PullSubscribe(subject, durable, opts...)
+		if err != nil {
+			return nil, err
+		}
+		handler := h.ServeMsg(nc)
+		go func() {
+			for sub.IsValid() {
+				msgs, err := sub.Fetch(batch, nats.MaxWait(pullMaxWait))
+				if err != nil && !errors.Is(err, nats.ErrTimeout) {
+					time.Sleep(pullMaxWait)
+				}
+				for _, msg := range msgs {
+					handler(msg)
+				}
+			}
+		}()
+		return sub, nil
+	}
+}
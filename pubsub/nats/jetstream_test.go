@@ -0,0 +1,127 @@
+package pubsubnats
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublisher_JetStreamDeduplication(t *testing.T) {
+	nc, js := runJetStreamServer(t)
+
+	p := NewPublisher(nc, PublisherJetStream(js), PublisherMsgID(func(_ context.Context, msg *nats.Msg) string {
+		return string(msg.Data)
+	}))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.Publish(context.Background(), "orders.created", "order-1"))
+	}
+	require.NoError(t, p.Publish(context.Background(), "orders.created", "order-2"))
+
+	info, err := js.StreamInfo("ORDERS")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), info.State.Msgs)
+}
+
+func TestSubscriber_JetStreamRedelivery(t *testing.T) {
+	nc, js := runJetStreamServer(t)
+
+	var calls int32
+	done := make(chan struct{})
+	e := func(_ context.Context, _ interface{}) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			return nil, errors.New("temporary failure", errors.IO)
+		}
+		close(done)
+		return nil, nil
+	}
+	h := NewSubscriber(e, NopRequestDecoder, SubscriberJetStream(), SubscriberNakDelay(10*time.Millisecond))
+
+	var tests = []struct {
+		subject   string
+		subscribe SubscriberFunc
+	}{
+		{subject: "orders.push", subscribe: SubscribeJetStream(nc, js, "orders.push", "push", h)},
+		{subject: "orders.pull", subscribe: PullSubscribeJetStream(nc, js, "orders.pull", "pull", h, 10)},
+	}
+
+	for _, tt := range tests {
+		atomic.StoreInt32(&calls, 0)
+		done = make(chan struct{})
+
+		sub, err := tt.subscribe()
+		require.NoError(t, err)
+		require.NoError(t, NewPublisher(nc, PublisherJetStream(js)).Publish(context.Background(), tt.subject, "payload"))
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: message was not redelivered", tt.subject)
+		}
+		sub.Unsubscribe()
+	}
+}
+
+func TestSubscriber_JetStreamDeadLetter(t *testing.T) {
+	nc, js := runJetStreamServer(t)
+
+	dlq, err := nc.SubscribeSync("dead.orders")
+	require.NoError(t, err)
+
+	var calls int32
+	e := func(_ context.Context, _ interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("downstream unavailable", errors.IO)
+	}
+	h := NewSubscriber(e, NopRequestDecoder,
+		SubscriberJetStream(),
+		SubscriberMaxDeliver(2),
+		SubscriberDeadLetter("dead.orders"),
+	)
+	sub, err := SubscribeJetStream(nc, js, "orders.created", "dlq", h, nats.MaxDeliver(2))()
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	require.NoError(t, NewPublisher(nc, PublisherJetStream(js)).Publish(context.Background(), "orders.created", "payload"))
+
+	msg, err := dlq.NextMsg(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, `"payload"`, string(msg.Data))
+	assert.Equal(t, "orders.created", msg.Header.Get(DeadLetterSubjectHdr))
+	assert.Equal(t, "2", msg.Header.Get(DeadLetterDeliveriesHdr))
+	assert.Contains(t, msg.Header.Get(DeadLetterErrorHdr), "downstream unavailable")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestSubscriber_JetStreamTerminatesPermanentErrors(t *testing.T) {
+	nc, js := runJetStreamServer(t)
+
+	dlq, err := nc.SubscribeSync("dead.orders")
+	require.NoError(t, err)
+
+	var calls int32
+	e := func(_ context.Context, _ interface{}) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, errors.New("invalid order", errors.Invalid)
+	}
+	h := NewSubscriber(e, NopRequestDecoder,
+		SubscriberJetStream(),
+		SubscriberMaxDeliver(5),
+		SubscriberDeadLetter("dead.orders"),
+	)
+	sub, err := SubscribeJetStream(nc, js, "orders.created", "terminal", h, nats.MaxDeliver(5))()
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	require.NoError(t, NewPublisher(nc, PublisherJetStream(js)).Publish(context.Background(), "orders.created", "payload"))
+
+	msg, err := dlq.NextMsg(5 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "1", msg.Header.Get(DeadLetterDeliveriesHdr))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
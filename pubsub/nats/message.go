@@ -0,0 +1,110 @@
+package pubsubnats
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/etherlabsio/pkg/pubsub"
+)
+
+// message adapts a NATS message to pubsub.Message. It is safe for concurrent
+// use.
+type message struct {
+	msg     *nats.Msg
+	settled atomic.Bool
+}
+
+// NewMessage returns msg as a pubsub.Message. Acknowledgements are only sent
+// for JetStream messages.
+func NewMessage(msg *nats.Msg) pubsub.Message {
+	return &message{msg: msg}
+}
+
+func (m *message) Subject() string             { return m.msg.Subject }
+func (m *message) Data() []byte                { return m.msg.Data }
+func (m *message) Header() map[string][]string { return m.msg.Header }
+
+func (m *message) Ack() error {
+	if !m.settle() {
+		return nil
+	}
+	return m.msg.Ack()
+}
+
+func (m *message) Nak() error {
+	if !m.settle() {
+		return nil
+	}
+	return m.msg.Nak()
+}
+
+// settle reports whether an acknowledgement must be sent for the message.
+func (m *message) settle() bool {
+	if !m.settled.CompareAndSwap(false, true) {
+		return false
+	}
+	_, err := m.msg.Metadata()
+	return err == nil
+}
+
+// HandlerOption sets an optional parameter for handlers adapted by NewHandler.
+type HandlerOption func(*handlerAdapter)
+
+// HandlerBaseContext sets the context messages are handled with, such as the
+// Context of a SubscriptionSet. It defaults to context.Background().
+func HandlerBaseContext(ctx context.Context) HandlerOption {
+	return func(a *handlerAdapter) { a.baseCtx = ctx }
+}
+
+// NewHandler adapts a transport neutral pubsub.Handler to a NATS Handler.
+// Messages are acked when h returns nil and nacked otherwise, unless h settled
+// them itself.
+func NewHandler(h pubsub.Handler, options ...HandlerOption) Handler {
+	a := handlerAdapter{next: h, baseCtx: context.Background()}
+	for _, option := range options {
+		option(&a)
+	}
+	return a
+}
+
+type handlerAdapter struct {
+	next    pubsub.Handler
+	baseCtx context.Context
+}
+
+func (a handlerAdapter) ServeMsg(_ *nats.Conn) func(msg *nats.Msg) {
+	return func(msg *nats.Msg) {
+		m := NewMessage(msg)
+		if err := a.next.HandleMessage(a.baseCtx, m); err != nil {
+			m.Nak()
+			return
+		}
+		m.Ack()
+	}
+}
+
+// MessageSubscriber implements pubsub.Subscriber on a NATS connection.
+type MessageSubscriber struct {
+	nc      *nats.Conn
+	options []SubscriptionOption
+}
+
+var _ pubsub.Subscriber = (*MessageSubscriber)(nil)
+
+// NewMessageSubscriber returns a pubsub.Subscriber subscribing on nc with the
+// given subscription options.
+func NewMessageSubscriber(nc *nats.Conn, options ...SubscriptionOption) *MessageSubscriber {
+	return &MessageSubscriber{nc: nc, options: options}
+}
+
+// Subscribe serves the messages published to subject with h. Messages are
+// handled with the values of ctx, but are not cancelled with it.
+func (s *MessageSubscriber) Subscribe(ctx context.Context, subject string, h pubsub.Handler) (pubsub.Subscription, error) {
+	sub, err := Subscribe(s.nc, subject, NewHandler(h, HandlerBaseContext(context.WithoutCancel(ctx))), s.options...)()
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
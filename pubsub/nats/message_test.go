@@ -0,0 +1,85 @@
+package pubsubnats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/etherlabsio/pkg/pubsub"
+)
+
+func TestMessageSubscriber(t *testing.T) {
+	nc := runServer(t)
+
+	received := make(chan pubsub.Message, 1)
+	h := pubsub.Chain(pubsub.Recovery())(pubsub.HandlerFunc(func(ctx context.Context, msg pubsub.Message) error {
+		received <- msg
+		return nil
+	}))
+
+	var s pubsub.Subscriber = NewMessageSubscriber(nc)
+	sub, err := s.Subscribe(context.Background(), "orders.*", h)
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	msg := nats.NewMsg("orders.created")
+	msg.Header.Set(CorrelationIDHdr, "req-1")
+	msg.Data = []byte("payload")
+	require.NoError(t, nc.PublishMsg(msg))
+
+	select {
+	case m := <-received:
+		assert.Equal(t, "orders.created", m.Subject())
+		assert.Equal(t, "payload", string(m.Data()))
+		assert.Equal(t, []string{"req-1"}, m.Header()[CorrelationIDHdr])
+		assert.NoError(t, m.Ack(), "ack is a no-op for core NATS messages")
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestNewHandler_SettlesJetStreamMessages(t *testing.T) {
+	nc, js := runJetStreamServer(t)
+
+	var calls int
+	done := make(chan struct{})
+	h := NewHandler(pubsub.HandlerFunc(func(ctx context.Context, msg pubsub.Message) error {
+		calls++
+		if calls == 1 {
+			return errors.Str("transient failure")
+		}
+		close(done)
+		return nil
+	}))
+	sub, err := SubscribeJetStream(nc, js, "orders.created", "adapter", h)()
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	_, err = js.Publish("orders.created", []byte("payload"))
+	require.NoError(t, err)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("nacked message was not redelivered")
+	}
+}
+
+func TestNewHandler_BaseContext(t *testing.T) {
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "base")
+
+	var got interface{}
+	h := NewHandler(pubsub.HandlerFunc(func(ctx context.Context, msg pubsub.Message) error {
+		got = ctx.Value(key{})
+		return nil
+	}), HandlerBaseContext(ctx))
+	h.ServeMsg(nil)(nats.NewMsg("orders.created"))
+
+	assert.Equal(t, "base", got)
+}
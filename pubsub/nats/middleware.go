@@ -0,0 +1,87 @@
+package pubsubnats
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats.go"
+
+	"github.com/etherlabsio/pkg/logutil"
+	"github.com/etherlabsio/pkg/natsutil"
+)
+
+// MsgHandlerFunc handles a message within ctx, which is passed down to the
+// subscriber request funcs, decoder and endpoint. It returns the decoding or
+// endpoint error, if any.
+type MsgHandlerFunc func(ctx context.Context, msg *nats.Msg) error
+
+// Middleware is a chainable decorator for the message handling of subscribers.
+type Middleware func(MsgHandlerFunc) MsgHandlerFunc
+
+// Chain is a helper function for composing middlewares. Messages will
+// traverse them in the order they're declared. That is, the first middleware
+// is treated as the outermost middleware.
+//
+// Chain is identical to the go-kit helper for Endpoint Middleware.
+func Chain(outer Middleware, others ...Middleware) Middleware {
+	return func(next MsgHandlerFunc) MsgHandlerFunc {
+		for i := len(others) - 1; i >= 0; i-- { // reverse
+			next = others[i](next)
+		}
+		return outer(next)
+	}
+}
+
+// SubscriberMiddleware wraps the message handling of the subscriber with mw,
+// the first one being the outermost.
+func SubscriberMiddleware(mw ...Middleware) SubscriberOption {
+	return func(s *Subscriber) { s.middleware = append(s.middleware, mw...) }
+}
+
+// Logging logs the subject, duration and error of every handled message.
+func Logging(logger log.Logger) Middleware {
+	return func(next MsgHandlerFunc) MsgHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) (err error) {
+			defer func(begin time.Time) {
+				logutil.WithError(logger, err).Log(
+					"component", "messaging_subscriber",
+					"subject", msg.Subject,
+					"took", time.Since(begin),
+				)
+			}(time.Now())
+			return next(ctx, msg)
+		}
+	}
+}
+
+// Timeout cancels the context of the message handling after d. See also
+// SubscriberTimeout.
+func Timeout(d time.Duration) Middleware {
+	return func(next MsgHandlerFunc) MsgHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			ctx, cancel := context.WithTimeout(ctx, d)
+			defer cancel()
+			return next(ctx, msg)
+		}
+	}
+}
+
+// FilterSubjects only handles the messages whose subject matches one of the
+// patterns, which may contain wildcards. Other messages are dropped, and acked
+// if they were delivered by JetStream.
+func FilterSubjects(patterns ...string) Middleware {
+	return func(next MsgHandlerFunc) MsgHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			for _, p := range patterns {
+				if natsutil.MatchSubject(p, msg.Subject) {
+					return next(ctx, msg)
+				}
+			}
+			if _, err := msg.Metadata(); err == nil {
+				return msg.Ack()
+			}
+			return nil
+		}
+	}
+}
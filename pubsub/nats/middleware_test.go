@@ -0,0 +1,125 @@
+package pubsubnats
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ExampleChain() {
+	e := func(context.Context, interface{}) (interface{}, error) {
+		fmt.Println("endpoint")
+		return nil, nil
+	}
+	h := NewSubscriber(e, NopRequestDecoder, SubscriberMiddleware(
+		annotate("one"),
+		annotate("two"),
+		annotate("three"),
+	))
+	h.ServeMsg(nil)(&nats.Msg{Subject: "orders.created"})
+
+	// Output:
+	// annotate:  one
+	// annotate:  two
+	// annotate:  three
+	// endpoint
+}
+
+func annotate(s string) Middleware {
+	return func(next MsgHandlerFunc) MsgHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			fmt.Println("annotate: ", s)
+			return next(ctx, msg)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var ctxErr error
+	e := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		select {
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+		case <-time.After(time.Second):
+		}
+		return nil, ctxErr
+	}
+	h := NewSubscriber(e, NopRequestDecoder, SubscriberMiddleware(Timeout(10*time.Millisecond)))
+	h.ServeMsg(nil)(&nats.Msg{Subject: "orders.created"})
+	assert.Equal(t, context.DeadlineExceeded, ctxErr)
+}
+
+func TestLogging(t *testing.T) {
+	var logged []map[interface{}]interface{}
+	logger := log.LoggerFunc(func(keyvals ...interface{}) error {
+		m := map[interface{}]interface{}{}
+		for i := 0; i < len(keyvals); i += 2 {
+			m[keyvals[i]] = keyvals[i+1]
+		}
+		logged = append(logged, m)
+		return nil
+	})
+	e := func(_ context.Context, request interface{}) (interface{}, error) {
+		return nil, errors.New("rejected", errors.Invalid)
+	}
+	h := NewSubscriber(e, NopRequestDecoder, SubscriberMiddleware(Logging(logger)))
+	h.ServeMsg(nil)(&nats.Msg{Subject: "orders.created"})
+
+	require.Len(t, logged, 1)
+	assert.Equal(t, "orders.created", logged[0]["subject"])
+	assert.Contains(t, logged[0]["err"], "rejected")
+	assert.NotNil(t, logged[0]["took"])
+}
+
+func TestFilterSubjects(t *testing.T) {
+	var served []string
+	e := func(_ context.Context, request interface{}) (interface{}, error) {
+		served = append(served, request.(string))
+		return nil, nil
+	}
+	dec := func(_ context.Context, msg *nats.Msg) (interface{}, error) { return msg.Subject, nil }
+	h := NewSubscriber(e, dec, SubscriberMiddleware(FilterSubjects("orders.*.created", "audit.>")))
+
+	for _, subject := range []string{"orders.eu.created", "orders.eu.cancelled", "audit.orders.created", "payments.created"} {
+		h.ServeMsg(nil)(&nats.Msg{Subject: subject})
+	}
+	assert.Equal(t, []string{"orders.eu.created", "audit.orders.created"}, served)
+}
+
+func TestFilterSubjects_AcksJetStream(t *testing.T) {
+	nc, js := runJetStreamServer(t)
+
+	served := make(chan string, 2)
+	e := func(_ context.Context, request interface{}) (interface{}, error) {
+		served <- request.(string)
+		return nil, nil
+	}
+	dec := func(_ context.Context, msg *nats.Msg) (interface{}, error) { return msg.Subject, nil }
+	h := NewSubscriber(e, dec, SubscriberJetStream(), SubscriberMiddleware(FilterSubjects("orders.created")))
+	sub, err := SubscribeJetStream(nc, js, "orders.>", "filtered", h)()
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	_, err = js.Publish("orders.cancelled", nil)
+	require.NoError(t, err)
+	_, err = js.Publish("orders.created", nil)
+	require.NoError(t, err)
+
+	select {
+	case subject := <-served:
+		assert.Equal(t, "orders.created", subject)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+	require.Eventually(t, func() bool {
+		info, err := js.ConsumerInfo("ORDERS", "filtered")
+		return err == nil && info.NumAckPending == 0 && info.NumPending == 0
+	}, time.Second, 10*time.Millisecond)
+}
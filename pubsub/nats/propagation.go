@@ -0,0 +1,168 @@
+package pubsubnats
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Headers used by the built-in propagators.
+const (
+	CorrelationIDHdr = "Correlation-Id"
+	TraceParentHdr   = "traceparent"
+	TraceStateHdr    = "tracestate"
+)
+
+type contextKey int
+
+const (
+	correlationIDKey contextKey = iota
+	traceContextKey
+	messageIDKey
+	receivedAtKey
+	consumedKey
+	handledCtxKey
+)
+
+// Propagator carries request scoped values from the publisher context over
+// the message headers into the subscriber context.
+type Propagator interface {
+	// Inject writes the values found in ctx to the outgoing headers.
+	Inject(ctx context.Context, h nats.Header)
+	// Extract restores the values found in the incoming headers into ctx.
+	Extract(ctx context.Context, h nats.Header) context.Context
+}
+
+// PublisherPropagators injects the context of every Publish call into the
+// message headers using the given propagators.
+func PublisherPropagators(propagators ...Propagator) PublisherOption {
+	return PublisherBefore(func(ctx context.Context, msg *nats.Msg) context.Context {
+		if msg.Header == nil {
+			msg.Header = nats.Header{}
+		}
+		for _, p := range propagators {
+			p.Inject(ctx, msg.Header)
+		}
+		return ctx
+	})
+}
+
+// SubscriberPropagators restores the publisher context from the message
+// headers before the message is decoded.
+func SubscriberPropagators(propagators ...Propagator) SubscriberOption {
+	return SubscriberBefore(func(ctx context.Context, msg *nats.Msg) context.Context {
+		for _, p := range propagators {
+			ctx = p.Extract(ctx, msg.Header)
+		}
+		return ctx
+	})
+}
+
+// ContextWithCorrelationID returns a copy of ctx carrying the correlation ID.
+func ContextWithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID carried by ctx, if any.
+func CorrelationIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationIDKey).(string)
+	return id, ok && id != ""
+}
+
+// CorrelationID propagates the correlation ID in the Correlation-Id header.
+// Messages published without one in the context are given a new ID, so that
+// every message flow can be correlated.
+func CorrelationID() Propagator {
+	return correlationIDPropagator{}
+}
+
+type correlationIDPropagator struct{}
+
+func (correlationIDPropagator) Inject(ctx context.Context, h nats.Header) {
+	if h.Get(CorrelationIDHdr) != "" {
+		return
+	}
+	id, ok := CorrelationIDFromContext(ctx)
+	if !ok {
+		id = nuid.Next()
+	}
+	h.Set(CorrelationIDHdr, id)
+}
+
+func (correlationIDPropagator) Extract(ctx context.Context, h nats.Header) context.Context {
+	if id := h.Get(CorrelationIDHdr); id != "" {
+		return ContextWithCorrelationID(ctx, id)
+	}
+	return ctx
+}
+
+// TraceContext is the W3C trace context of a message.
+//
+// see https://www.w3.org/TR/trace-context/
+type TraceContext struct {
+	TraceParent string
+	TraceState  string
+}
+
+var traceParentRegexp = regexp.MustCompile(`^[0-9a-f]{2}-[0-9a-f]{32}-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// Valid reports whether the traceparent is well formed.
+func (tc TraceContext) Valid() bool {
+	return traceParentRegexp.MatchString(tc.TraceParent)
+}
+
+// ContextWithTraceContext returns a copy of ctx carrying the trace context.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey, tc)
+}
+
+// TraceContextFromContext returns the trace context carried by ctx, if any.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey).(TraceContext)
+	return tc, ok
+}
+
+// W3CTraceContext propagates the trace context in the traceparent and
+// tracestate headers, which are encoded by the OpenTelemetry W3C propagator,
+// as with PublisherTracing and SubscriberTracing. The OpenTelemetry span
+// context of ctx, if any, takes precedence over its TraceContext, and with
+// PublisherTracing the producer span is propagated instead. Malformed
+// traceparent headers are ignored.
+func W3CTraceContext() Propagator {
+	return traceContextPropagator{}
+}
+
+type traceContextPropagator struct{}
+
+var w3cPropagator propagation.TraceContext
+
+func (traceContextPropagator) Inject(ctx context.Context, h nats.Header) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		tc, ok := TraceContextFromContext(ctx)
+		if !ok || !tc.Valid() {
+			return
+		}
+		ctx = w3cPropagator.Extract(ctx, propagation.MapCarrier{
+			TraceParentHdr: tc.TraceParent,
+			TraceStateHdr:  tc.TraceState,
+		})
+	}
+	w3cPropagator.Inject(ctx, headerCarrier(h))
+}
+
+func (traceContextPropagator) Extract(ctx context.Context, h nats.Header) context.Context {
+	// the span context is not set in ctx, as that would replace the span
+	// started by SubscriberTracing
+	sc := trace.SpanContextFromContext(w3cPropagator.Extract(context.Background(), headerCarrier(h)))
+	if !sc.IsValid() {
+		return ctx
+	}
+	return ContextWithTraceContext(ctx, TraceContext{
+		TraceParent: h.Get(TraceParentHdr),
+		TraceState:  sc.TraceState().String(),
+	})
+}
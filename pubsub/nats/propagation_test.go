@@ -0,0 +1,84 @@
+package pubsubnats
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPropagators_PublishToSubscriber(t *testing.T) {
+	nc := runServer(t)
+
+	type result struct {
+		correlationID string
+		trace         TraceContext
+	}
+	results := make(chan result, 1)
+	e := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		var r result
+		r.correlationID, _ = CorrelationIDFromContext(ctx)
+		r.trace, _ = TraceContextFromContext(ctx)
+		results <- r
+		return nil, nil
+	}
+
+	h := NewSubscriber(e, NopRequestDecoder, SubscriberPropagators(CorrelationID(), W3CTraceContext()))
+	sub, err := nc.Subscribe("orders.created", h.ServeMsg(nc))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	tc := TraceContext{
+		TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		TraceState:  "congo=t61rcWkgMzE",
+	}
+	ctx := ContextWithCorrelationID(context.Background(), "req-1")
+	ctx = ContextWithTraceContext(ctx, tc)
+
+	p := NewPublisher(nc, PublisherPropagators(CorrelationID(), W3CTraceContext()))
+	require.NoError(t, p.Publish(ctx, "orders.created", "payload"))
+
+	select {
+	case r := <-results:
+		assert.Equal(t, "req-1", r.correlationID)
+		assert.Equal(t, tc, r.trace)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestCorrelationID_GeneratedWhenMissing(t *testing.T) {
+	h := nats.Header{}
+	CorrelationID().Inject(context.Background(), h)
+	assert.NotEmpty(t, h.Get(CorrelationIDHdr))
+}
+
+func TestW3CTraceContext_IgnoresMalformedHeaders(t *testing.T) {
+	h := nats.Header{}
+	h.Set(TraceParentHdr, "not-a-traceparent")
+
+	ctx := W3CTraceContext().Extract(context.Background(), h)
+	_, ok := TraceContextFromContext(ctx)
+	assert.False(t, ok)
+}
+
+func TestW3CTraceContext_PrefersSpanContext(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+	ctx = ContextWithTraceContext(ctx, TraceContext{
+		TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	})
+
+	h := nats.Header{}
+	W3CTraceContext().Inject(ctx, h)
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", h.Get(TraceParentHdr))
+}
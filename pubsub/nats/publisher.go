@@ -11,16 +11,33 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Publisher wraps a URL and provides a method that implements endpoint.Endpoint.
 type Publisher struct {
 	publisher *nats.Conn
+	js        nats.JetStreamContext
+	msgID     MsgIDFunc
 	enc       natstransport.EncodeRequestFunc
 	before    []natstransport.RequestFunc
 	after     []natstransport.RequestFunc
 	logger    log.Logger
 	timeout   time.Duration
+
+	cloudEventSource string
+
+	buffer        int
+	batchSize     int
+	batchInterval time.Duration
+	async         *asyncPublisher
+
+	breaker       *breaker
+	spoolCoolDown time.Duration
+
+	metrics *Metrics
+	tracer  trace.Tracer
 }
 
 // NewPublisher constructs a usable Publisher for a single remote method.
@@ -33,10 +50,23 @@ func NewPublisher(
 		enc:       EncodeJSONRequest,
 		logger:    log.NewNopLogger(),
 		timeout:   10 * time.Second,
+
+		batchSize:     64,
+		batchInterval: 100 * time.Millisecond,
 	}
 	for _, option := range options {
 		option(p)
 	}
+	if p.breaker != nil {
+		if p.spoolCoolDown > 0 {
+			p.breaker.coolDown = p.spoolCoolDown
+		}
+		p.replayOnReconnect()
+	}
+	if p.buffer > 0 {
+		p.async = newAsyncPublisher(p.buffer)
+		go p.runAsync()
+	}
 	return p
 }
 
@@ -49,11 +79,35 @@ func PublisherBefore(before ...natstransport.RequestFunc) PublisherOption {
 	return func(p *Publisher) { p.before = append(p.before, before...) }
 }
 
+// PublisherEncoder sets the request encoder. It defaults to EncodeJSONRequest.
+func PublisherEncoder(enc natstransport.EncodeRequestFunc) PublisherOption {
+	return func(p *Publisher) { p.enc = enc }
+}
+
 // PublisherTimeout sets the available timeout for NATS request.
 func PublisherTimeout(timeout time.Duration) PublisherOption {
 	return func(p *Publisher) { p.timeout = timeout }
 }
 
+// MsgIDFunc derives the JetStream deduplication ID for an outgoing message.
+// Publishes carrying the same ID within the stream's duplicate window are
+// stored only once.
+type MsgIDFunc func(ctx context.Context, msg *nats.Msg) string
+
+// PublisherJetStream publishes messages through the JetStream context and
+// waits for the stream to acknowledge them, instead of fire-and-forget core
+// NATS publishes. Unless PublisherMsgID is set, every message is given a
+// unique ID so that retries of the same Publish call are deduplicated.
+func PublisherJetStream(js nats.JetStreamContext) PublisherOption {
+	return func(p *Publisher) { p.js = js }
+}
+
+// PublisherMsgID sets the function used to derive the JetStream message ID.
+// It is only used together with PublisherJetStream.
+func PublisherMsgID(f MsgIDFunc) PublisherOption {
+	return func(p *Publisher) { p.msgID = f }
+}
+
 func PublisherLogger(l log.Logger) PublisherOption {
 	return func(p *Publisher) { p.logger = log.With(l, "component", "messaging_publisher") }
 }
@@ -100,12 +154,34 @@ func (p Publisher) Publish(ctx context.Context, subject string, e interface{}) e
 		)
 		return errors.Errorf("%s: encoder failure for topic %s and payload %+v", op, subject, e)
 	}
+	if p.cloudEventSource != "" {
+		if err := wrapCloudEvent(&msg, p.cloudEventSource, false); err != nil {
+			level.Error(p.logger).Log(
+				"topic", msg.Subject,
+				"status", "failed",
+				"err", err,
+			)
+			return errors.Errorf("%s: cloudevent failure for topic %s", op, subject)
+		}
+	}
 
 	for _, f := range p.before {
 		ctx = f(ctx, &msg)
 	}
 
-	err := p.publisher.Publish(msg.Subject, msg.Data)
+	if p.async != nil {
+		if err := p.async.enqueue(ctx, asyncMsg{ctx: ctx, msg: &msg}); err != nil {
+			level.Error(p.logger).Log(
+				"topic", msg.Subject,
+				"status", "failed",
+				"err", err,
+			)
+			return errors.WithMessagef(err, "%s: enqueue failure for topic %s", op, subject)
+		}
+		return nil
+	}
+
+	err := p.publish(ctx, &msg)
 	if err != nil {
 		level.Error(p.logger).Log(
 			"topic", msg.Subject,
@@ -122,6 +198,36 @@ func (p Publisher) Publish(ctx context.Context, subject string, e interface{}) e
 	return nil
 }
 
+func (p Publisher) publish(ctx context.Context, msg *nats.Msg) error {
+	if p.metrics == nil && p.tracer == nil {
+		return p.deliver(ctx, msg)
+	}
+	return p.instrument(ctx, msg, p.deliver)
+}
+
+// deliver sends msg, or spools it while the breaker is open.
+func (p Publisher) deliver(ctx context.Context, msg *nats.Msg) error {
+	if p.breaker != nil {
+		return p.breaker.publish(ctx, msg, p.send, p.logger)
+	}
+	return p.send(ctx, msg)
+}
+
+func (p Publisher) send(ctx context.Context, msg *nats.Msg) error {
+	if p.js == nil {
+		return p.publisher.PublishMsg(msg)
+	}
+	id := msg.Header.Get(nats.MsgIdHdr)
+	if id == "" && p.msgID != nil {
+		id = p.msgID(ctx, msg)
+	}
+	if id == "" {
+		id = nuid.Next()
+	}
+	_, err := p.js.PublishMsg(msg, nats.MsgId(id), nats.Context(ctx))
+	return err
+}
+
 // EncodeJSONRequest is an EncodeRequestFunc that serializes the request as a
 // JSON object to the Data of the Msg. Many JSON-over-NATS services can use it as
 // a sensible default.
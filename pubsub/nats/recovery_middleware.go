@@ -1,36 +1,65 @@
 package pubsubnats
 
 import (
-	"fmt"
+	"context"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	natstransport "github.com/go-kit/kit/transport/nats"
 	"github.com/nats-io/nats.go"
-	"github.com/pkg/errors"
+
+	"github.com/etherlabsio/pkg/logutil"
 )
 
 // RecoveryMiddleware intercepts the nats messages and performs recovery on panic
 type RecoveryMiddleware struct {
-	logger log.Logger
-	next   Handler
+	logger       log.Logger
+	next         Handler
+	errorEncoder natstransport.ErrorEncoder
+	panics       metrics.Counter
+}
+
+// RecoveryOption sets an optional parameter for the RecoveryMiddleware.
+type RecoveryOption func(*RecoveryMiddleware)
+
+// RecoveryErrorEncoder is used to reply the recovered panics of request
+// messages having a reply subject, as SubscriberErrorEncoder does. By default,
+// panics are not replied.
+func RecoveryErrorEncoder(ee natstransport.ErrorEncoder) RecoveryOption {
+	return func(mw *RecoveryMiddleware) { mw.errorEncoder = ee }
 }
 
-// NewRecoveryMiddleware returns as RecoveryMiddleware handler
-func NewRecoveryMiddleware(l log.Logger, h Handler) Handler {
-	return RecoveryMiddleware{l, h}
+// RecoveryPanics counts the recovered panics.
+func RecoveryPanics(c metrics.Counter) RecoveryOption {
+	return func(mw *RecoveryMiddleware) { mw.panics = c }
+}
+
+// NewRecoveryMiddleware returns as RecoveryMiddleware handler. Subscribers
+// recover their panics on their own and need no RecoveryMiddleware.
+func NewRecoveryMiddleware(l log.Logger, h Handler, options ...RecoveryOption) Handler {
+	mw := RecoveryMiddleware{logger: l, next: h}
+	for _, option := range options {
+		option(&mw)
+	}
+	return mw
 }
 
-// ServeMsg wraps the serveMsg handler with the stacktrace
+// ServeMsg wraps the serveMsg handler, converting panics into errors.Internal
+// errors which are logged along with their stack, and replied to request
+// messages if an error encoder is set. JetStream messages are never replied.
 func (mw RecoveryMiddleware) ServeMsg(nc *nats.Conn) func(msg *nats.Msg) {
 	handler := mw.next.ServeMsg(nc)
 	return func(msg *nats.Msg) {
 		defer func() {
 			if rvr := recover(); rvr != nil {
-				err := errors.New(fmt.Sprintf("Panic: %+v", rvr))
-				err = errors.WithStack(err)
-				mw.logger.Log(
-					"err", fmt.Sprintf("%+v", err),
-					"subject", msg.Subject,
-				)
+				err := logutil.PanicError(rvr)
+				logutil.LogPanic(mw.logger, mw.panics, err, "subject", msg.Subject)
+				if mw.errorEncoder == nil || msg.Reply == "" || nc == nil {
+					return
+				}
+				if _, mdErr := msg.Metadata(); mdErr != nil {
+					mw.errorEncoder(context.Background(), err, msg.Reply, nc)
+				}
 			}
 		}()
 
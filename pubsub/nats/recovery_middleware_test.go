@@ -2,10 +2,15 @@ package pubsubnats
 
 import (
 	"testing"
+	"time"
 
 	"github.com/etherlabsio/pkg/logutil"
+	"github.com/etherlabsio/pkg/natsutil"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/generic"
 	"github.com/nats-io/nats.go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type mockRecoveryHandler struct{}
@@ -31,3 +36,26 @@ func TestRecoveryMiddleware_ServeMsg(t *testing.T) {
 		})
 	})
 }
+
+func TestRecoveryMiddleware_Reply(t *testing.T) {
+	nc := runServer(t)
+
+	panics := generic.NewCounter("panics")
+	for subject, options := range map[string][]RecoveryOption{
+		"orders.silent":  {RecoveryPanics(panics)},
+		"orders.replied": {RecoveryPanics(panics), RecoveryErrorEncoder(natsutil.JSONErrorEncoder(log.NewNopLogger()))},
+	} {
+		h := NewRecoveryMiddleware(log.NewNopLogger(), mockRecoveryHandler{}, options...)
+		sub, err := nc.Subscribe(subject, h.ServeMsg(nc))
+		require.NoError(t, err)
+		defer sub.Unsubscribe()
+	}
+
+	_, err := nc.Request("orders.silent", nil, 100*time.Millisecond)
+	assert.Equal(t, nats.ErrTimeout, err)
+
+	reply, err := nc.Request("orders.replied", nil, time.Second)
+	require.NoError(t, err)
+	assert.Contains(t, string(reply.Data), "something went wrong")
+	assert.Equal(t, 2.0, panics.Value())
+}
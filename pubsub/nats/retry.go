@@ -0,0 +1,72 @@
+package pubsubnats
+
+import (
+	"context"
+	"time"
+
+	"github.com/etherlabsio/errors"
+)
+
+// Backoff returns the delay to wait before the given retry, starting at 1.
+type Backoff func(retry int) time.Duration
+
+// ExponentialBackoff doubles the delay for every retry starting at base,
+// without exceeding max.
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(retry int) time.Duration {
+		d := base
+		for i := 1; i < retry && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			return max
+		}
+		return d
+	}
+}
+
+// RetryableKinds classifies errors of the given kinds as retryable. Errors
+// without a kind are treated as errors.Internal.
+func RetryableKinds(kinds ...errors.Kind) func(error) bool {
+	return func(err error) bool {
+		kind := errors.KindOf(err)
+		for _, k := range kinds {
+			if k == kind {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SubscriberRetry invokes the endpoint up to the given number of attempts
+// for as long as it fails with a retryable error, waiting for the backoff
+// between attempts. By default the endpoint is invoked once.
+func SubscriberRetry(attempts int, backoff Backoff) SubscriberOption {
+	return func(s *Subscriber) {
+		s.attempts = attempts
+		s.backoff = backoff
+	}
+}
+
+// SubscriberRetryable sets the classifier deciding which endpoint errors are
+// retried. By default errors.Internal and errors.IO errors are retryable.
+func SubscriberRetryable(retryable func(error) bool) SubscriberOption {
+	return func(s *Subscriber) { s.retryable = retryable }
+}
+
+// serve invokes the endpoint with retries and returns the number of attempts
+// made along with the last error.
+func (s Subscriber) serve(ctx context.Context, request interface{}) (int, error) {
+	for attempt := 1; ; attempt++ {
+		_, err := s.e(ctx, request)
+		if err == nil || attempt >= s.attempts || !s.retryable(err) {
+			return attempt, err
+		}
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(s.backoff(attempt)):
+		}
+	}
+}
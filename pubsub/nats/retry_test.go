@@ -0,0 +1,102 @@
+package pubsubnats
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff(100*time.Millisecond, time.Second)
+
+	var tests = []struct {
+		retry int
+		want  time.Duration
+	}{
+		{retry: 1, want: 100 * time.Millisecond},
+		{retry: 2, want: 200 * time.Millisecond},
+		{retry: 4, want: 800 * time.Millisecond},
+		{retry: 5, want: time.Second},
+		{retry: 50, want: time.Second},
+	}
+
+	for _, tt := range tests {
+		if have := backoff(tt.retry); have != tt.want {
+			t.Errorf("retry %d: have %s, want %s", tt.retry, have, tt.want)
+		}
+	}
+}
+
+func TestRetryableKinds(t *testing.T) {
+	retryable := RetryableKinds(errors.Internal, errors.IO)
+
+	assert.True(t, retryable(errors.Str("plain error")))
+	assert.True(t, retryable(errors.New("network failure", errors.IO)))
+	assert.False(t, retryable(errors.New("bad input", errors.Invalid)))
+	assert.False(t, retryable(errors.WithKind(errors.Str("missing"), errors.NotExist, "lookup failed")))
+}
+
+func TestSubscriber_RetryAndDeadLetter(t *testing.T) {
+	nc := runServer(t)
+
+	var tests = []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{name: "retryable errors exhaust all attempts", err: errors.New("timeout", errors.IO), attempts: 3},
+		{name: "permanent errors are not retried", err: errors.New("bad input", errors.Invalid), attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dlq, err := nc.SubscribeSync("dead.letter")
+			require.NoError(t, err)
+			defer dlq.Unsubscribe()
+
+			var calls int
+			e := func(_ context.Context, _ interface{}) (interface{}, error) {
+				calls++
+				return nil, tt.err
+			}
+			h := NewSubscriber(e, NopRequestDecoder,
+				SubscriberRetry(3, ExponentialBackoff(time.Millisecond, 5*time.Millisecond)),
+				SubscriberDeadLetter("dead.letter"),
+			)
+
+			msg := nats.NewMsg("orders.created")
+			msg.Header.Set("Correlation-Id", "abc")
+			msg.Data = []byte("payload")
+			h.ServeMsg(nc)(msg)
+
+			dl, err := dlq.NextMsg(time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, "payload", string(dl.Data))
+			assert.Equal(t, "abc", dl.Header.Get("Correlation-Id"))
+			assert.Equal(t, "orders.created", dl.Header.Get(DeadLetterSubjectHdr))
+			assert.Equal(t, strconv.Itoa(tt.attempts), dl.Header.Get(DeadLetterAttemptsHdr))
+			assert.Equal(t, tt.attempts, calls)
+		})
+	}
+}
+
+func TestSubscriber_RetryRecovers(t *testing.T) {
+	var calls int
+	e := func(_ context.Context, _ interface{}) (interface{}, error) {
+		calls++
+		if calls < 2 {
+			return nil, errors.Str("flaky")
+		}
+		return nil, nil
+	}
+	h := NewSubscriber(e, NopRequestDecoder, SubscriberRetry(3, ExponentialBackoff(time.Millisecond, time.Millisecond)))
+	h.ServeMsg(nil)(&nats.Msg{Subject: "orders.created"})
+
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,208 @@
+package pubsubnats
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/nats-io/nats.go"
+)
+
+// Spool is an append-only file of messages waiting to be published, kept on
+// disk so that they survive restarts. It is safe for concurrent use.
+//
+// Replayed messages are removed by rewriting the remaining ones to a new
+// file, so a crash during Replay may publish some of them again.
+type Spool struct {
+	mu       sync.Mutex
+	path     string
+	f        *os.File
+	size     int64
+	depth    int
+	maxBytes int64
+	gauge    metrics.Gauge
+}
+
+// SpoolOption sets an optional parameter for spools.
+type SpoolOption func(*Spool)
+
+// SpoolMaxBytes caps the size of the spool file. Messages that don't fit are
+// rejected. It defaults to 64MB.
+func SpoolMaxBytes(n int64) SpoolOption {
+	return func(s *Spool) { s.maxBytes = n }
+}
+
+// SpoolDepth sets the gauge tracking the number of spooled messages.
+func SpoolDepth(g metrics.Gauge) SpoolOption {
+	return func(s *Spool) { s.gauge = g }
+}
+
+type spoolRecord struct {
+	Subject string      `json:"subject"`
+	Reply   string      `json:"reply,omitempty"`
+	Header  nats.Header `json:"header,omitempty"`
+	Data    []byte      `json:"data"`
+}
+
+// OpenSpool opens the spool file at path, creating it if needed. Messages
+// spooled by a previous process are kept, while a record left incomplete by a
+// crash is discarded. Records are stored as JSON lines.
+func OpenSpool(path string, opts ...SpoolOption) (*Spool, error) {
+	const op errors.Op = "pubsubnats.OpenSpool"
+	s := &Spool{
+		path:     path,
+		maxBytes: 64 << 20,
+		gauge:    discard.NewGauge(),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.New("open spool "+path, err, op, errors.IO)
+	}
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil || !json.Valid(line) {
+			break
+		}
+		s.depth++
+		s.size += int64(len(line))
+	}
+	if err := f.Truncate(s.size); err != nil {
+		f.Close()
+		return nil, errors.New("truncate spool "+path, err, op, errors.IO)
+	}
+	s.f = f
+	s.gauge.Set(float64(s.depth))
+	return s, nil
+}
+
+// Append appends msg to the spool. A record left incomplete by a failed
+// write is removed, leaving the spool unchanged.
+func (s *Spool) Append(msg *nats.Msg) error {
+	const op errors.Op = "pubsubnats.Spool.Append"
+	b, err := json.Marshal(spoolRecord{
+		Subject: msg.Subject,
+		Reply:   msg.Reply,
+		Header:  msg.Header,
+		Data:    msg.Data,
+	})
+	if err != nil {
+		return errors.New("encode spool record", err, op, errors.Invalid)
+	}
+	b = append(b, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(len(b)) > s.maxBytes {
+		return errors.New("spool "+s.path+" full", op, errors.IO)
+	}
+	if n, err := s.f.Write(b); err != nil {
+		// drop the partial record so that the next one starts on its own line
+		if n > 0 {
+			if terr := s.f.Truncate(s.size); terr != nil {
+				return errors.New("truncate spool "+s.path, terr, op, errors.IO)
+			}
+		}
+		return errors.New("write spool "+s.path, err, op, errors.IO)
+	}
+	s.size += int64(len(b))
+	s.depth++
+	s.gauge.Set(float64(s.depth))
+	return nil
+}
+
+// Len returns the number of spooled messages.
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.depth
+}
+
+// Replay calls f with the spooled messages in order, removing them from the
+// spool. It stops at the first error, keeping the message that failed and the
+// ones after it.
+func (s *Spool) Replay(f func(*nats.Msg) error) error {
+	const op errors.Op = "pubsubnats.Spool.Replay"
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.depth == 0 {
+		return nil
+	}
+
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return errors.New("seek spool "+s.path, err, op, errors.IO)
+	}
+	lines := bufio.NewReader(io.LimitReader(s.f, s.size))
+	var offset int64
+	for replayed := 0; replayed < s.depth; replayed++ {
+		line, err := lines.ReadBytes('\n')
+		if err != nil {
+			return errors.New("read spool "+s.path, err, op, errors.IO)
+		}
+		var r spoolRecord
+		if err := json.Unmarshal(line, &r); err != nil {
+			return errors.New("decode spool "+s.path, err, op, errors.IO)
+		}
+		msg := &nats.Msg{Subject: r.Subject, Reply: r.Reply, Header: r.Header, Data: r.Data}
+		if err := f(msg); err != nil {
+			if cerr := s.compact(offset, replayed); cerr != nil {
+				return cerr
+			}
+			return err
+		}
+		offset += int64(len(line))
+	}
+	return s.compact(s.size, s.depth)
+}
+
+// compact removes the first n records, ending at offset, from the spool.
+func (s *Spool) compact(offset int64, n int) error {
+	const op errors.Op = "pubsubnats.Spool.compact"
+	if n == 0 {
+		return nil
+	}
+
+	rest, err := os.OpenFile(s.path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.New("create spool "+s.path, err, op, errors.IO)
+	}
+	if _, err := s.f.Seek(offset, io.SeekStart); err != nil {
+		rest.Close()
+		return errors.New("seek spool "+s.path, err, op, errors.IO)
+	}
+	size, err := io.Copy(rest, io.LimitReader(s.f, s.size-offset))
+	if err == nil {
+		err = rest.Sync()
+	}
+	if err == nil {
+		err = os.Rename(rest.Name(), s.path)
+	}
+	if err != nil {
+		rest.Close()
+		os.Remove(rest.Name())
+		return errors.New("compact spool "+s.path, err, op, errors.IO)
+	}
+
+	s.f.Close()
+	s.f = rest
+	s.size = size
+	s.depth -= n
+	s.gauge.Set(float64(s.depth))
+	return nil
+}
+
+// Close closes the spool file.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
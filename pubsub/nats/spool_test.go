@@ -0,0 +1,211 @@
+package pubsubnats
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool_AppendReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+	depth := generic.NewGauge("depth")
+	s, err := OpenSpool(path, SpoolDepth(depth))
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		msg := &nats.Msg{Subject: "orders.created", Header: nats.Header{"N": []string{strconv.Itoa(i)}}, Data: []byte(strconv.Itoa(i))}
+		require.NoError(t, s.Append(msg))
+	}
+	assert.Equal(t, 5.0, depth.Value())
+	require.NoError(t, s.Close())
+
+	// a record torn by a crash is dropped on open
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"subject":"orders.cre`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	s, err = OpenSpool(path, SpoolDepth(depth))
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Equal(t, 5, s.Len())
+
+	var replayed []string
+	failAt := 3
+	replay := func(msg *nats.Msg) error {
+		if len(replayed) == failAt {
+			return errors.Str("publish failed")
+		}
+		assert.Equal(t, string(msg.Data), msg.Header.Get("N"))
+		replayed = append(replayed, string(msg.Data))
+		return nil
+	}
+	assert.Error(t, s.Replay(replay))
+	assert.Equal(t, 2, s.Len())
+	assert.Equal(t, 2.0, depth.Value())
+
+	require.NoError(t, s.Append(&nats.Msg{Subject: "orders.created", Header: nats.Header{"N": []string{"5"}}, Data: []byte("5")}))
+	failAt = -1
+	require.NoError(t, s.Replay(replay))
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5"}, replayed)
+	assert.Equal(t, 0, s.Len())
+	assert.Equal(t, 0.0, depth.Value())
+}
+
+func TestSpool_MaxBytes(t *testing.T) {
+	s, err := OpenSpool(filepath.Join(t.TempDir(), "spool"), SpoolMaxBytes(150))
+	require.NoError(t, err)
+	defer s.Close()
+
+	msg := &nats.Msg{Subject: "orders.created", Data: []byte("0123456789")}
+	require.NoError(t, s.Append(msg))
+	require.NoError(t, s.Append(msg))
+	assert.Error(t, s.Append(msg))
+	assert.Equal(t, 2, s.Len())
+}
+
+func TestPublisherSpool_ReplaysOnReconnect(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	srv := natsserver.RunServer(&opts)
+	opts.Port = srv.Addr().(*net.TCPAddr).Port
+	url := srv.ClientURL()
+
+	reconnected := make(chan struct{}, 1)
+	connOpts := append(WithDefaultConnectOptions("test", log.NewNopLogger()),
+		nats.ReconnectWait(10*time.Millisecond),
+		nats.ReconnectBufSize(-1),
+	)
+	nc, err := nats.Connect(url, connOpts...)
+	require.NoError(t, err)
+	defer nc.Close()
+	sub, err := nc.SubscribeSync("orders.created")
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	spool, err := OpenSpool(filepath.Join(t.TempDir(), "spool"))
+	require.NoError(t, err)
+	defer spool.Close()
+	p := NewPublisher(nc, PublisherSpool(spool, 2))
+	next := nc.ReconnectHandler()
+	nc.SetReconnectHandler(func(c *nats.Conn) {
+		next(c)
+		reconnected <- struct{}{}
+	})
+
+	ctx := context.Background()
+	require.NoError(t, p.Publish(ctx, "orders.created", 0))
+	require.NoError(t, nc.Flush())
+
+	srv.Shutdown()
+	for nc.IsConnected() {
+		time.Sleep(time.Millisecond)
+	}
+	// failures are returned until the breaker trips, then messages are spooled
+	assert.Error(t, p.Publish(ctx, "orders.created", 1))
+	for i := 2; i < 5; i++ {
+		require.NoError(t, p.Publish(ctx, "orders.created", i))
+	}
+	assert.Equal(t, 3, spool.Len())
+
+	srv = natsserver.RunServer(&opts)
+	defer srv.Shutdown()
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("not reconnected")
+	}
+
+	for _, want := range []string{"0", "2", "3", "4"} {
+		msg, err := sub.NextMsg(5 * time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(msg.Data))
+	}
+	assert.Eventually(t, func() bool { return spool.Len() == 0 }, time.Second, time.Millisecond)
+
+	require.NoError(t, p.Publish(ctx, "orders.created", 5))
+	msg, err := sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "5", string(msg.Data))
+}
+
+func TestPublisherSpool_ReturnsRejectedMessages(t *testing.T) {
+	nc := runServer(t)
+	spool, err := OpenSpool(filepath.Join(t.TempDir(), "spool"))
+	require.NoError(t, err)
+	defer spool.Close()
+	p := NewPublisher(nc, PublisherEncoder(func(_ context.Context, msg *nats.Msg, request interface{}) error {
+		msg.Data = request.([]byte)
+		return nil
+	}), PublisherSpool(spool, 1))
+
+	ctx := context.Background()
+	tooLarge := make([]byte, nc.MaxPayload()+1)
+	for i := 0; i < 3; i++ {
+		assert.Error(t, p.Publish(ctx, "orders.created", tooLarge))
+	}
+	assert.Equal(t, 0, spool.Len())
+
+	sub, err := nc.SubscribeSync("orders.created")
+	require.NoError(t, err)
+	require.NoError(t, p.Publish(ctx, "orders.created", []byte("ok")))
+	_, err = sub.NextMsg(time.Second)
+	assert.NoError(t, err)
+}
+
+func TestPublisherSpoolCoolDown(t *testing.T) {
+	nc := runServer(t)
+	spool, err := OpenSpool(filepath.Join(t.TempDir(), "spool"))
+	require.NoError(t, err)
+	defer spool.Close()
+
+	p := NewPublisher(nc, PublisherSpoolCoolDown(time.Minute), PublisherSpool(spool, 1))
+	assert.Equal(t, time.Minute, p.breaker.coolDown, "cool-down set before the spool")
+
+	p = NewPublisher(nc, PublisherSpool(spool, 1))
+	assert.Equal(t, defaultCoolDown, p.breaker.coolDown)
+}
+
+func TestBreaker_HalfOpen(t *testing.T) {
+	spool, err := OpenSpool(filepath.Join(t.TempDir(), "spool"))
+	require.NoError(t, err)
+	defer spool.Close()
+	b := &breaker{spool: spool, threshold: 1, coolDown: 20 * time.Millisecond}
+
+	var sent []string
+	connected := false
+	send := func(_ context.Context, msg *nats.Msg) error {
+		if !connected {
+			return nats.ErrConnectionClosed
+		}
+		sent = append(sent, msg.Subject)
+		return nil
+	}
+	ctx, logger := context.Background(), log.NewNopLogger()
+
+	require.NoError(t, b.publish(ctx, &nats.Msg{Subject: "a"}, send, logger))
+	connected = true
+	require.NoError(t, b.publish(ctx, &nats.Msg{Subject: "b"}, send, logger))
+	assert.Empty(t, sent, "messages are spooled during the cool-down")
+	assert.Equal(t, 2, spool.Len())
+
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, b.publish(ctx, &nats.Msg{Subject: "c"}, send, logger))
+	assert.Equal(t, []string{"a", "b", "c"}, sent)
+	assert.Equal(t, 0, spool.Len())
+	assert.False(t, b.open)
+}
@@ -2,14 +2,19 @@ package pubsubnats
 
 import (
 	"context"
+	"time"
 
+	"github.com/etherlabsio/errors"
 	natstransport "github.com/go-kit/kit/transport/nats"
 
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
+	"github.com/go-kit/kit/metrics"
 
 	"github.com/nats-io/nats.go"
+
+	"github.com/etherlabsio/pkg/logutil"
 )
 
 // DecodeRequestFunc extracts a user-domain request object from a publisher
@@ -20,10 +25,27 @@ type DecodeRequestFunc natstransport.DecodeRequestFunc
 
 // Subscriber wraps an endpoint and provides nats.MsgHandler.
 type Subscriber struct {
-	e      endpoint.Endpoint
-	dec    natstransport.DecodeRequestFunc
-	before []natstransport.RequestFunc
-	logger log.Logger
+	e          endpoint.Endpoint
+	dec        natstransport.DecodeRequestFunc
+	before     []natstransport.RequestFunc
+	finalizer  []SubscriberFinalizerFunc
+	middleware []Middleware
+	logger     log.Logger
+
+	jetstream  bool
+	nakDelay   time.Duration
+	maxDeliver int
+	deadLetter string
+
+	attempts  int
+	backoff   Backoff
+	retryable func(error) bool
+
+	errorEncoder natstransport.ErrorEncoder
+	panics       metrics.Counter
+
+	baseCtx context.Context
+	timeout time.Duration
 }
 
 // NewSubscriber constructs a new subscriber, which provides nats.MsgHandler and wraps
@@ -34,16 +56,25 @@ func NewSubscriber(
 	options ...SubscriberOption,
 ) Handler {
 	s := &Subscriber{
-		e:      e,
-		dec:    dec,
-		logger: log.NewNopLogger(),
+		e:         e,
+		dec:       dec,
+		baseCtx:   context.Background(),
+		logger:    log.NewNopLogger(),
+		attempts:  1,
+		backoff:   ExponentialBackoff(100*time.Millisecond, 10*time.Second),
+		retryable: RetryableKinds(errors.Internal, errors.IO),
+	}
+
+	// recover innermost, so that panics are retried and settled like errors
+	s.e = func(ctx context.Context, request interface{}) (interface{}, error) {
+		return logutil.RecoveryMiddleware(s.logger, s.panics)(e)(ctx, request)
 	}
 
 	for _, option := range options {
 		option(s)
 	}
 
-	return NewRecoveryMiddleware(s.logger, s)
+	return s
 }
 
 // SubscriberOption sets an optional parameter for subscribers.
@@ -55,6 +86,47 @@ func SubscriberBefore(before ...natstransport.RequestFunc) SubscriberOption {
 	return func(s *Subscriber) { s.before = append(s.before, before...) }
 }
 
+// SubscriberFinalizerFunc can be used to perform work at the end of a message
+// handling, after the message is settled. err is the decoding or endpoint
+// error, if any.
+type SubscriberFinalizerFunc func(ctx context.Context, msg *nats.Msg, err error)
+
+// SubscriberFinalizer is executed at the end of every message handled by the
+// subscriber. By default, no finalizer is registered.
+func SubscriberFinalizer(f ...SubscriberFinalizerFunc) SubscriberOption {
+	return func(s *Subscriber) { s.finalizer = append(s.finalizer, f...) }
+}
+
+// SubscriberTimeout sets the deadline for handling a message, after which the
+// context passed to the middlewares, request funcs, decoder and endpoint is
+// cancelled. It is the Timeout middleware, applied outside of those set with
+// SubscriberMiddleware. By default there is no deadline.
+func SubscriberTimeout(d time.Duration) SubscriberOption {
+	return func(s *Subscriber) { s.timeout = d }
+}
+
+// SubscriberBaseContext sets the context the message contexts derive from, so
+// that cancelling it cancels the messages being handled. Use the Context of
+// the SubscriptionSet owning the subscription to cancel them when the set is
+// drained or closed. It defaults to context.Background().
+func SubscriberBaseContext(ctx context.Context) SubscriberOption {
+	return func(s *Subscriber) { s.baseCtx = ctx }
+}
+
+// SubscriberErrorEncoder is used to reply the decoding and endpoint errors,
+// and recovered panics, of request messages having a reply subject, such as
+// natsutil.JSONErrorEncoder. By default, errors are not replied. JetStream
+// messages are never replied.
+func SubscriberErrorEncoder(ee natstransport.ErrorEncoder) SubscriberOption {
+	return func(s *Subscriber) { s.errorEncoder = ee }
+}
+
+// SubscriberPanics counts the panics recovered while serving messages, which
+// are converted into errors.Internal errors.
+func SubscriberPanics(c metrics.Counter) SubscriberOption {
+	return func(s *Subscriber) { s.panics = c }
+}
+
 // SubscriberErrorLogger is used to log non-terminal errors. By default, no errors
 // are logged. This is intended as a diagnostic measure. Finer-grained control
 // of error handling, including logging in more detail, should be performed in a
@@ -65,40 +137,96 @@ func SubscriberErrorLogger(logger log.Logger) SubscriberOption {
 
 // Serve provides nats.MsgHandler.
 func (s Subscriber) ServeMsg(nc *nats.Conn) func(msg *nats.Msg) {
+	handle := func(ctx context.Context, msg *nats.Msg) error {
+		return s.handle(ctx, nc, msg)
+	}
+	if len(s.middleware) > 0 {
+		handle = Chain(s.middleware[0], s.middleware[1:]...)(handle)
+	}
+	if s.timeout > 0 {
+		handle = Timeout(s.timeout)(handle)
+	}
 	return func(msg *nats.Msg) {
-		ctx, cancel := context.WithCancel(context.Background())
+		var err error
+		ctx, cancel := context.WithCancel(s.baseCtx)
 		defer cancel()
 
+		ctx = context.WithValue(ctx, receivedAtKey, time.Now())
+		// the finalizers see the context returned by the request funcs
+		fctx := ctx
+		ctx = context.WithValue(ctx, handledCtxKey, &fctx)
 		logger := log.With(s.logger, "subject", msg.Subject)
 
-		for _, f := range s.before {
-			ctx = f(ctx, msg)
+		if len(s.finalizer) > 0 {
+			defer func() {
+				for _, f := range s.finalizer {
+					f(fctx, msg, err)
+				}
+			}()
 		}
+		// recover around the middlewares, so that their panics are settled too
+		defer func() {
+			if rvr := recover(); rvr != nil {
+				err = logutil.PanicError(rvr)
+				logutil.LogPanic(logger, s.panics, err)
+				s.handleError(fctx, err, msg, nc)
+				s.settle(nc, msg, err, true, 0, logger)
+			}
+		}()
+
+		err = handle(ctx, msg)
+	}
+}
 
-		request, err := s.dec(ctx, msg)
-		if err != nil {
-			logger.Log(
-				"msg", "error decoding nats msg",
-				"err", err,
-			)
-			errHandler(ctx, err, msg, nc)
-			return
-		}
+// handle decodes and serves msg, and settles it.
+func (s Subscriber) handle(ctx context.Context, nc *nats.Conn, msg *nats.Msg) error {
+	logger := log.With(s.logger, "subject", msg.Subject)
 
-		_, err = s.e(ctx, request)
-		if err != nil {
-			logger.Log(
-				"msg", "endpoint error for nats msg",
-				"err", err,
-			)
-			errHandler(ctx, err, msg, nc)
-			return
-		}
+	for _, f := range s.before {
+		ctx = f(ctx, msg)
+	}
+	if fctx, ok := ctx.Value(handledCtxKey).(*context.Context); ok {
+		*fctx = ctx
 	}
+
+	request, err := s.dec(ctx, msg)
+	if err != nil {
+		logger.Log(
+			"msg", "error decoding nats msg",
+			"err", err,
+		)
+		s.handleError(ctx, err, msg, nc)
+		s.settle(nc, msg, err, true, 0, logger)
+		return err
+	}
+
+	attempts, err := s.serve(ctx, request)
+	if err != nil {
+		logger.Log(
+			"msg", "endpoint error for nats msg",
+			"err", err,
+			"attempts", attempts,
+		)
+		s.handleError(ctx, err, msg, nc)
+		s.settle(nc, msg, err, !s.retryable(err), attempts, logger)
+		return err
+	}
+	s.settle(nc, msg, nil, false, attempts, logger)
+	return nil
 }
 
-// TODO: Add a way to handle errors
-func errHandler(ctx context.Context, err error, msg *nats.Msg, nc *nats.Conn) {}
+// handleError replies err to request messages. JetStream messages are not
+// replied, as their reply subject is the ack subject; failures are settled
+// instead.
+func (s Subscriber) handleError(ctx context.Context, err error, msg *nats.Msg, nc *nats.Conn) {
+	if msg.Reply == "" || nc == nil || s.errorEncoder == nil || s.jetstream {
+		return
+	}
+	if _, mdErr := msg.Metadata(); mdErr == nil {
+		return
+	}
+	s.errorEncoder(ctx, err, msg.Reply, nc)
+}
 
 // NopRequestDecoder is a DecodeRequestFunc that can be used for requests that do not
 // need to be decoded, and simply returns nil, nil.
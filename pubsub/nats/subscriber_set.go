@@ -1,43 +1,236 @@
 package pubsubnats
 
 import (
-	"fmt"
+	"context"
 	"io"
 	"strings"
+	"sync"
+	"time"
 
 	nats "github.com/nats-io/nats.go"
 )
 
+// DrainTimeout bounds the time Run waits for in-flight messages on shutdown.
+var DrainTimeout = 30 * time.Second
+
+// drainPollInterval is how often Drain checks for completed subscriptions.
+const drainPollInterval = 10 * time.Millisecond
+
+var _ io.Closer = SubscriptionSet{}
+
+// SubscriptionSet manages the lifecycle of a group of subscriptions, which
+// are either drained gracefully or closed together. Like the sets returned by
+// Register, copies of a set share its subscriptions.
+//
+// Subscribers created with SubscriberBaseContext(set.Context()) have the
+// messages they are still handling cancelled once the set is closed, or
+// drained past its deadline. Such a set must be created by NewSubscriptionSet
+// before registering them.
+//
+// Handlers that keep serving messages after returning, such as a WorkerPool,
+// are registered with RegisterDrainers so that Drain waits for them too.
 type SubscriptionSet struct {
+	// Closer is unused and kept for compatibility.
 	io.Closer
-	Err           error
+	Err error
+
+	*setState
+}
+
+type setState struct {
+	mu            sync.Mutex
 	subscriptions []*nats.Subscription
+	drainers      []Drainer
+
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 type SubscriberFunc func() (*nats.Subscription, error)
 
+// Drainer is implemented by handlers that serve messages asynchronously,
+// such as WorkerPool. Drain stops them from accepting messages and waits
+// until the accepted ones are served or ctx is done.
+type Drainer interface {
+	Drain(ctx context.Context) error
+}
+
+// NewSubscriptionSet returns an empty SubscriptionSet.
+func NewSubscriptionSet() SubscriptionSet {
+	ctx, cancel := context.WithCancel(context.Background())
+	return SubscriptionSet{setState: &setState{ctx: ctx, cancel: cancel}}
+}
+
+// RegisterSubscribers returns a new SubscriptionSet with the subscriptions
+// created by funcs. See Register.
 func RegisterSubscribers(funcs ...SubscriberFunc) SubscriptionSet {
-	return SubscriptionSet{}.Register(funcs...)
+	return NewSubscriptionSet().Register(funcs...)
 }
 
+// Register creates the subscriptions in order and returns the set holding
+// them. If any of them fails, the subscriptions already created by this call
+// are unsubscribed and the error is recorded in Err.
 func (registry SubscriptionSet) Register(funcs ...SubscriberFunc) SubscriptionSet {
+	if registry.setState == nil {
+		registry.setState = NewSubscriptionSet().setState
+	}
+	var subs []*nats.Subscription
 	for _, f := range funcs {
-		uSub, err := f()
+		sub, err := f()
 		if err != nil {
+			for _, s := range subs {
+				s.Unsubscribe()
+			}
 			registry.Err = err
-			break
+			return registry
 		}
-		registry.subscriptions = append(registry.subscriptions, uSub)
+		subs = append(subs, sub)
 	}
+
+	registry.mu.Lock()
+	registry.subscriptions = append(registry.subscriptions, subs...)
+	registry.mu.Unlock()
 	return registry
 }
 
+// RegisterDrainers returns the set with drainers added. They are drained in
+// order once the subscriptions are, and before the set context is cancelled.
+func (registry SubscriptionSet) RegisterDrainers(drainers ...Drainer) SubscriptionSet {
+	if registry.setState == nil {
+		registry.setState = NewSubscriptionSet().setState
+	}
+	registry.mu.Lock()
+	registry.drainers = append(registry.drainers, drainers...)
+	registry.mu.Unlock()
+	return registry
+}
+
+// Run blocks until ctx is done or the set is closed, and then drains the
+// subscriptions waiting up to DrainTimeout. It returns immediately if the
+// registration failed.
+func (registry SubscriptionSet) Run(ctx context.Context) error {
+	if registry.Err != nil {
+		return registry.Err
+	}
+	select {
+	case <-ctx.Done():
+	case <-registry.Context().Done():
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DrainTimeout)
+	defer cancel()
+	return registry.Drain(ctx)
+}
+
+// Drain stops the subscriptions from receiving new messages and waits until
+// the pending and in-flight messages are served, including those handed to
+// the registered drainers. If ctx is done first, the remaining subscriptions
+// are closed and ctx.Err() is returned.
+func (registry SubscriptionSet) Drain(ctx context.Context) error {
+	defer registry.shutdown()
+
+	var errs multiError
+	subs := registry.active()
+	for _, sub := range subs {
+		if err := sub.Drain(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for len(subs) > 0 {
+		select {
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			errs = append(errs, unsubscribe(subs)...)
+			return errs.err()
+		case <-ticker.C:
+			subs = valid(subs)
+		}
+	}
+
+	for _, d := range registry.registeredDrainers() {
+		if err := d.Drain(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err()
+}
+
+// Context returns a context cancelled once the set is drained or closed. The
+// context of a zero SubscriptionSet is never cancelled.
+func (registry SubscriptionSet) Context() context.Context {
+	if registry.setState == nil {
+		return context.Background()
+	}
+	return registry.ctx
+}
+
+// Close unsubscribes from all the subscriptions, dropping any pending
+// messages.
 func (registry SubscriptionSet) Close() error {
-	var errstrings []string
-	for _, sub := range registry.subscriptions {
-		if err := sub.Unsubscribe(); err != nil {
-			errstrings = append(errstrings, err.Error())
+	defer registry.shutdown()
+	return multiError(unsubscribe(registry.active())).err()
+}
+
+func (registry SubscriptionSet) active() []*nats.Subscription {
+	if registry.setState == nil {
+		return nil
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return valid(registry.subscriptions)
+}
+
+func (registry SubscriptionSet) registeredDrainers() []Drainer {
+	if registry.setState == nil {
+		return nil
+	}
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	return append([]Drainer(nil), registry.drainers...)
+}
+
+func (registry SubscriptionSet) shutdown() {
+	if registry.setState != nil {
+		registry.cancel()
+	}
+}
+
+func valid(subs []*nats.Subscription) []*nats.Subscription {
+	var active []*nats.Subscription
+	for _, sub := range subs {
+		if sub.IsValid() {
+			active = append(active, sub)
+		}
+	}
+	return active
+}
+
+func unsubscribe(subs []*nats.Subscription) []error {
+	var errs []error
+	for _, sub := range subs {
+		if err := sub.Unsubscribe(); err != nil && err != nats.ErrBadSubscription {
+			errs = append(errs, err)
 		}
 	}
-	return fmt.Errorf(strings.Join(errstrings, "\n"))
+	return errs
+}
+
+// multiError aggregates the errors of operations over several subscriptions.
+type multiError []error
+
+func (m multiError) Error() string {
+	errstrings := make([]string, len(m))
+	for i, err := range m {
+		errstrings[i] = err.Error()
+	}
+	return strings.Join(errstrings, "\n")
+}
+
+func (m multiError) err() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
 }
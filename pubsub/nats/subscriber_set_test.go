@@ -0,0 +1,199 @@
+package pubsubnats
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionSet_Close(t *testing.T) {
+	nc := runServer(t)
+	h := handlerFunc(func(msg *nats.Msg) {})
+
+	set := RegisterSubscribers(
+		Subscribe(nc, "orders.created", h),
+		Subscribe(nc, "orders.cancelled", h),
+	)
+	require.NoError(t, set.Err)
+
+	assert.NoError(t, set.Close())
+	assert.NoError(t, set.Close(), "closing twice should not fail")
+}
+
+func TestSubscriptionSet_RegisterRollback(t *testing.T) {
+	nc := runServer(t)
+	h := handlerFunc(func(msg *nats.Msg) {})
+
+	var first *nats.Subscription
+	set := RegisterSubscribers(
+		func() (*nats.Subscription, error) {
+			var err error
+			first, err = Subscribe(nc, "orders.created", h)()
+			return first, err
+		},
+		func() (*nats.Subscription, error) {
+			return nil, errors.Str("subscription failure")
+		},
+	)
+
+	assert.EqualError(t, set.Err, "subscription failure")
+	assert.False(t, first.IsValid())
+}
+
+func TestSubscriptionSet_Drain(t *testing.T) {
+	nc := runServer(t)
+
+	var served int32
+	h := handlerFunc(func(msg *nats.Msg) {
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&served, 1)
+	})
+	set := RegisterSubscribers(Subscribe(nc, "orders.created", h))
+	require.NoError(t, set.Err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, nc.Publish("orders.created", nil))
+	}
+	require.NoError(t, nc.Flush())
+
+	require.NoError(t, set.Drain(context.Background()))
+	assert.Equal(t, int32(5), atomic.LoadInt32(&served))
+}
+
+func TestSubscriptionSet_DrainTimeout(t *testing.T) {
+	nc := runServer(t)
+
+	release := make(chan struct{})
+	defer close(release)
+	h := handlerFunc(func(msg *nats.Msg) { <-release })
+	set := RegisterSubscribers(Subscribe(nc, "orders.created", h))
+	require.NoError(t, set.Err)
+
+	require.NoError(t, nc.Publish("orders.created", nil))
+	require.NoError(t, nc.Flush())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := set.Drain(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+}
+
+func TestSubscriptionSet_Run(t *testing.T) {
+	nc := runServer(t)
+	set := RegisterSubscribers(Subscribe(nc, "orders.created", handlerFunc(func(msg *nats.Msg) {})))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error)
+	go func() { errc <- set.Run(ctx) }()
+
+	cancel()
+	select {
+	case err := <-errc:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Run did not return on shutdown")
+	}
+	assert.Empty(t, set.active())
+}
+
+func TestSubscriptionSet_CancelsInFlightMessages(t *testing.T) {
+	nc := runServer(t)
+
+	set := NewSubscriptionSet()
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	e := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	}
+	h := NewSubscriber(e, NopRequestDecoder, SubscriberBaseContext(set.Context()))
+	require.NoError(t, set.Register(Subscribe(nc, "orders.created", h)).Err)
+
+	require.NoError(t, nc.Publish("orders.created", nil))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := set.Drain(ctx)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+
+	select {
+	case err := <-cancelled:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("in-flight message not cancelled")
+	}
+	assert.Error(t, set.Context().Err())
+}
+
+func TestSubscriptionSet_DrainWaitsForWorkerPool(t *testing.T) {
+	nc := runServer(t)
+
+	set := NewSubscriptionSet()
+	var served, cancelled int32
+	e := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		select {
+		case <-time.After(20 * time.Millisecond):
+			atomic.AddInt32(&served, 1)
+		case <-ctx.Done():
+			atomic.AddInt32(&cancelled, 1)
+		}
+		return nil, nil
+	}
+	pool := NewWorkerPool(NewSubscriber(e, NopRequestDecoder, SubscriberBaseContext(set.Context())))
+	set = set.Register(Subscribe(nc, "orders.created", pool)).RegisterDrainers(pool)
+	require.NoError(t, set.Err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, nc.Publish("orders.created", nil))
+	}
+	require.NoError(t, nc.Flush())
+
+	require.NoError(t, set.Drain(context.Background()))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&served))
+	assert.Zero(t, atomic.LoadInt32(&cancelled))
+}
+
+func TestSubscriberTimeout(t *testing.T) {
+	var deadline bool
+	e := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		_, deadline = ctx.Deadline()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	h := NewSubscriber(e, NopRequestDecoder, SubscriberTimeout(10*time.Millisecond))
+
+	done := make(chan struct{})
+	go func() {
+		h.ServeMsg(nil)(&nats.Msg{Subject: "orders.created"})
+		close(done)
+	}()
+	select {
+	case <-done:
+		assert.True(t, deadline)
+	case <-time.After(time.Second):
+		t.Fatal("endpoint not cancelled")
+	}
+}
+
+func TestSubscriptionSet_ZeroValueRegister(t *testing.T) {
+	nc := runServer(t)
+
+	set := SubscriptionSet{}.Register(Subscribe(nc, "orders.created", handlerFunc(func(msg *nats.Msg) {})))
+	require.NoError(t, set.Err)
+	require.Len(t, set.active(), 1)
+
+	require.NoError(t, set.Close())
+	assert.Empty(t, set.active())
+	assert.Error(t, set.Context().Err())
+}
@@ -0,0 +1,139 @@
+package pubsubnats
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/generic"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/etherlabsio/pkg/natsutil"
+)
+
+func TestSubscriber_PanicReplied(t *testing.T) {
+	nc := runServer(t)
+
+	panics := generic.NewCounter("panics")
+	e := func(context.Context, interface{}) (interface{}, error) {
+		panic("something went wrong")
+	}
+	h := NewSubscriber(e, NopRequestDecoder,
+		SubscriberPanics(panics),
+		SubscriberErrorEncoder(natsutil.JSONErrorEncoder(log.NewNopLogger())),
+	)
+	sub, err := nc.Subscribe("orders.get", h.ServeMsg(nc))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	reply, err := nc.Request("orders.get", nil, time.Second)
+	require.NoError(t, err)
+	var body struct {
+		Error struct {
+			Code errors.Kind `json:"code"`
+		} `json:"error"`
+	}
+	require.NoError(t, json.Unmarshal(reply.Data, &body))
+	assert.Equal(t, errors.Internal, body.Error.Code)
+	assert.Equal(t, 1.0, panics.Value())
+}
+
+func TestSubscriber_PanicDeadLettered(t *testing.T) {
+	nc := runServer(t)
+	dlq, err := nc.SubscribeSync("orders.dlq")
+	require.NoError(t, err)
+
+	dec := func(context.Context, *nats.Msg) (interface{}, error) {
+		panic("cannot decode")
+	}
+	var finalErr error
+	h := NewSubscriber(endpoint.Nop, dec,
+		SubscriberDeadLetter("orders.dlq"),
+		SubscriberFinalizer(func(_ context.Context, _ *nats.Msg, err error) { finalErr = err }),
+	)
+	assert.NotPanics(t, func() {
+		h.ServeMsg(nc)(&nats.Msg{Subject: "orders.created", Data: []byte("{}")})
+	})
+	assert.True(t, errors.IsKind(finalErr, errors.Internal))
+
+	msg, err := dlq.NextMsg(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "orders.created", msg.Header.Get(DeadLetterSubjectHdr))
+}
+
+func TestSubscriber_MiddlewarePanicRecovered(t *testing.T) {
+	nc := runServer(t)
+	dlq, err := nc.SubscribeSync("orders.dlq")
+	require.NoError(t, err)
+
+	panics := generic.NewCounter("panics")
+	mw := func(MsgHandlerFunc) MsgHandlerFunc {
+		return func(context.Context, *nats.Msg) error {
+			panic("something went wrong")
+		}
+	}
+	var finalErr error
+	h := NewSubscriber(endpoint.Nop, NopRequestDecoder,
+		SubscriberMiddleware(mw),
+		SubscriberPanics(panics),
+		SubscriberDeadLetter("orders.dlq"),
+		SubscriberFinalizer(func(_ context.Context, _ *nats.Msg, err error) { finalErr = err }),
+	)
+	assert.NotPanics(t, func() {
+		h.ServeMsg(nc)(&nats.Msg{Subject: "orders.created", Data: []byte("{}")})
+	})
+	assert.True(t, errors.IsKind(finalErr, errors.Internal))
+	assert.Equal(t, 1.0, panics.Value())
+
+	_, err = dlq.NextMsg(time.Second)
+	require.NoError(t, err)
+}
+
+func TestSubscriber_ErrorReplied(t *testing.T) {
+	nc := runServer(t)
+
+	e := func(context.Context, interface{}) (interface{}, error) {
+		return nil, errors.New("order not found", errors.NotExist)
+	}
+	h := NewSubscriber(e, NopRequestDecoder, SubscriberErrorEncoder(natsutil.JSONErrorEncoder(log.NewNopLogger())))
+	sub, err := nc.Subscribe("orders.get", h.ServeMsg(nc))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	reply, err := nc.Request("orders.get", nil, time.Second)
+	require.NoError(t, err)
+	assert.Contains(t, string(reply.Data), "order not found")
+}
+
+func TestSubscriber_ErrorNotRepliedByDefault(t *testing.T) {
+	nc := runServer(t)
+
+	e := func(context.Context, interface{}) (interface{}, error) {
+		return nil, errors.New("order not found", errors.NotExist)
+	}
+	sub, err := nc.Subscribe("orders.get", NewSubscriber(e, NopRequestDecoder).ServeMsg(nc))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	_, err = nc.Request("orders.get", nil, 100*time.Millisecond)
+	assert.Equal(t, nats.ErrTimeout, err)
+}
+
+func TestSubscriberTimeout_CoversMiddlewares(t *testing.T) {
+	var deadline bool
+	mw := func(next MsgHandlerFunc) MsgHandlerFunc {
+		return func(ctx context.Context, msg *nats.Msg) error {
+			_, deadline = ctx.Deadline()
+			return next(ctx, msg)
+		}
+	}
+	h := NewSubscriber(endpoint.Nop, NopRequestDecoder, SubscriberTimeout(time.Second), SubscriberMiddleware(mw))
+	h.ServeMsg(nil)(&nats.Msg{Subject: "orders.created"})
+	assert.True(t, deadline)
+}
@@ -0,0 +1,163 @@
+package pubsubnats
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/nats-io/nats.go"
+)
+
+type subscription struct {
+	queue        string
+	pendingMsgs  int
+	pendingBytes int
+	logger       log.Logger
+}
+
+// SubscriptionOption sets an optional parameter for subscriptions.
+type SubscriptionOption func(*subscription)
+
+// SubscriptionQueue subscribes as a member of the queue group, so that every
+// message is served by a single member of the group.
+func SubscriptionQueue(group string) SubscriptionOption {
+	return func(s *subscription) { s.queue = group }
+}
+
+// SubscriptionPendingLimits sets the number of messages and bytes buffered
+// by the subscription before messages are dropped as a slow consumer.
+// Zero values keep the NATS defaults and negative values disable the limit.
+func SubscriptionPendingLimits(msgs, bytes int) SubscriptionOption {
+	return func(s *subscription) {
+		s.pendingMsgs = msgs
+		s.pendingBytes = bytes
+	}
+}
+
+// SubscriptionLogger is used to log asynchronous errors of the subscription,
+// such as messages dropped by a slow consumer.
+func SubscriptionLogger(l log.Logger) SubscriptionOption {
+	return func(s *subscription) { s.logger = log.With(l, "component", "messaging_subscription") }
+}
+
+// Subscribe returns a SubscriberFunc which subscribes h to subject on nc.
+// It is meant to be registered into a SubscriptionSet.
+//
+//	set := pubsubnats.RegisterSubscribers(
+//		pubsubnats.Subscribe(nc, OrderCreatedTopic, h, pubsubnats.SubscriptionQueue("orders")),
+//	)
+func Subscribe(nc *nats.Conn, subject string, h Handler, options ...SubscriptionOption) SubscriberFunc {
+	s := subscription{
+		pendingMsgs:  nats.DefaultSubPendingMsgsLimit,
+		pendingBytes: nats.DefaultSubPendingBytesLimit,
+	}
+	for _, option := range options {
+		option(&s)
+	}
+	if s.pendingMsgs == 0 {
+		s.pendingMsgs = nats.DefaultSubPendingMsgsLimit
+	}
+	if s.pendingBytes == 0 {
+		s.pendingBytes = nats.DefaultSubPendingBytesLimit
+	}
+
+	return func() (*nats.Subscription, error) {
+		sub, err := nc.QueueSubscribe(subject, s.queue, h.ServeMsg(nc))
+		if err != nil {
+			return nil, err
+		}
+		if err := sub.SetPendingLimits(s.pendingMsgs, s.pendingBytes); err != nil {
+			sub.Unsubscribe()
+			return nil, err
+		}
+		s.logAsyncErrors(nc, sub)
+		return sub, nil
+	}
+}
+
+// logAsyncErrors logs the asynchronous errors of sub. Unless the error
+// handler of nc already dispatches them, as set up by Connect, it is wrapped
+// once by an asyncErrors.
+func (s subscription) logAsyncErrors(nc *nats.Conn, sub *nats.Subscription) {
+	if s.logger == nil {
+		return
+	}
+	asyncErrorsMu.Lock()
+	h := nc.ErrorHandler()
+	if !dispatchesAsyncErrors(h) {
+		h = newAsyncErrors(h).handle
+		nc.SetErrorHandler(h)
+	}
+	asyncErrorsMu.Unlock()
+	h(nc, sub, registerLogger{s.logger})
+}
+
+// asyncErrorsMu serializes the wrapping of connection error handlers.
+var asyncErrorsMu sync.Mutex
+
+// asyncErrors logs the asynchronous errors of the subscriptions of a
+// connection with their own logger, before calling the next error handler.
+// Its handle method is set as the error handler of the connection, so that
+// it lives as long as the connection.
+type asyncErrors struct {
+	next nats.ErrHandler
+
+	mu      sync.Mutex
+	loggers map[*nats.Subscription]log.Logger
+}
+
+func newAsyncErrors(next nats.ErrHandler) *asyncErrors {
+	return &asyncErrors{next: next, loggers: map[*nats.Subscription]log.Logger{}}
+}
+
+// registerLogger is passed to the handle method of asyncErrors to register
+// the logger of a subscription.
+type registerLogger struct{ logger log.Logger }
+
+func (registerLogger) Error() string { return "pubsubnats: register subscription logger" }
+
+// handleCode identifies the error handlers set to the handle method of an
+// asyncErrors, since method values share their code pointer.
+var handleCode = reflect.ValueOf((*asyncErrors)(nil).handle).Pointer()
+
+func dispatchesAsyncErrors(h nats.ErrHandler) bool {
+	return h != nil && reflect.ValueOf(h).Pointer() == handleCode
+}
+
+func (e *asyncErrors) handle(c *nats.Conn, sub *nats.Subscription, err error) {
+	if r, ok := err.(registerLogger); ok {
+		e.register(sub, r.logger)
+		return
+	}
+	if sub != nil {
+		e.mu.Lock()
+		logger, ok := e.loggers[sub]
+		e.mu.Unlock()
+		if ok {
+			dropped, _ := sub.Dropped()
+			level.Error(logger).Log(
+				"subject", sub.Subject,
+				"queue", sub.Queue,
+				"dropped", dropped,
+				"err", err,
+			)
+		}
+	}
+	if e.next != nil {
+		e.next(c, sub, err)
+	}
+}
+
+// register adds the logger of sub, and removes the ones of the subscriptions
+// which have been unsubscribed or closed with the connection.
+func (e *asyncErrors) register(sub *nats.Subscription, logger log.Logger) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for s := range e.loggers {
+		if !s.IsValid() {
+			delete(e.loggers, s)
+		}
+	}
+	e.loggers[sub] = logger
+}
@@ -0,0 +1,131 @@
+package pubsubnats
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscribe_QueueGroup(t *testing.T) {
+	nc := runServer(t)
+
+	var served int32
+	var wg sync.WaitGroup
+	wg.Add(10)
+	h := handlerFunc(func(msg *nats.Msg) {
+		atomic.AddInt32(&served, 1)
+		wg.Done()
+	})
+
+	set := RegisterSubscribers(
+		Subscribe(nc, "orders.created", h, SubscriptionQueue("orders")),
+		Subscribe(nc, "orders.created", h, SubscriptionQueue("orders")),
+	)
+	require.NoError(t, set.Err)
+	defer set.Close()
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, nc.Publish("orders.created", nil))
+	}
+	wg.Wait()
+	require.NoError(t, nc.Flush())
+	time.Sleep(10 * time.Millisecond)
+
+	assert.Equal(t, int32(10), atomic.LoadInt32(&served))
+}
+
+func TestSubscribe_LogsSlowConsumer(t *testing.T) {
+	nc := runServer(t)
+
+	logged := make(chan []interface{}, 10)
+	logger := log.LoggerFunc(func(keyvals ...interface{}) error {
+		logged <- keyvals
+		return nil
+	})
+
+	release := make(chan struct{})
+	h := handlerFunc(func(msg *nats.Msg) { <-release })
+
+	sub, err := Subscribe(nc, "orders.created", h,
+		SubscriptionPendingLimits(1, -1),
+		SubscriptionLogger(logger),
+	)()
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, nc.Publish("orders.created", nil))
+	}
+	require.NoError(t, nc.Flush())
+	close(release)
+
+	select {
+	case keyvals := <-logged:
+		assert.Contains(t, keyvals, "orders.created")
+		assert.Contains(t, keyvals, nats.ErrSlowConsumer)
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer not logged")
+	}
+}
+
+func TestSubscribe_WrapsErrorHandlerOnce(t *testing.T) {
+	nc := runServer(t)
+
+	var handled int32
+	nc.SetErrorHandler(func(*nats.Conn, *nats.Subscription, error) { atomic.AddInt32(&handled, 1) })
+
+	var logged [3]int32
+	release := make(chan struct{})
+	defer close(release)
+	for i := range logged {
+		i := i
+		logger := log.LoggerFunc(func(...interface{}) error {
+			atomic.AddInt32(&logged[i], 1)
+			return nil
+		})
+		h := handlerFunc(func(msg *nats.Msg) { <-release })
+		sub, err := Subscribe(nc, fmt.Sprintf("orders.%d", i), h,
+			SubscriptionPendingLimits(1, -1),
+			SubscriptionLogger(logger),
+		)()
+		require.NoError(t, err)
+		defer sub.Unsubscribe()
+	}
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, nc.Publish("orders.0", nil))
+	}
+	require.NoError(t, nc.Flush())
+
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&handled) > 0 }, time.Second, 10*time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, atomic.LoadInt32(&handled), atomic.LoadInt32(&logged[0]))
+	assert.Zero(t, atomic.LoadInt32(&logged[1]))
+	assert.Zero(t, atomic.LoadInt32(&logged[2]))
+}
+
+func TestSubscribe_RemovesUnsubscribedLoggers(t *testing.T) {
+	nc := runServer(t)
+	errs := newAsyncErrors(nil)
+	nc.SetErrorHandler(errs.handle)
+
+	h := handlerFunc(func(msg *nats.Msg) {})
+	sub, err := Subscribe(nc, "orders.created", h, SubscriptionLogger(log.NewNopLogger()))()
+	require.NoError(t, err)
+	require.NoError(t, sub.Unsubscribe())
+	sub, err = Subscribe(nc, "orders.cancelled", h, SubscriptionLogger(log.NewNopLogger()))()
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	errs.mu.Lock()
+	defer errs.mu.Unlock()
+	assert.Len(t, errs.loggers, 1)
+	assert.Contains(t, errs.loggers, sub)
+}
@@ -0,0 +1,182 @@
+package pubsubnats
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+)
+
+var _ Drainer = (*WorkerPool)(nil)
+
+// KeyFunc derives the ordering key of a message.
+type KeyFunc func(msg *nats.Msg) string
+
+// WorkerPool is a Handler which serves messages on a bounded number of
+// workers instead of the subscription goroutine, so that a slow endpoint does
+// not stall the whole subject.
+//
+// Once the queues are full, ServeMsg blocks the subscription and messages
+// build up in the NATS pending buffer instead, whose limits are set with
+// nats.Subscription.SetPendingLimits.
+//
+// A pool serving the subscriptions of a SubscriptionSet should be registered
+// with RegisterDrainers, so that draining the set waits for its workers.
+type WorkerPool struct {
+	next        Handler
+	concurrency int
+	pending     int
+	key         KeyFunc
+
+	once    sync.Once
+	mu      sync.RWMutex
+	closed  bool
+	senders sync.WaitGroup
+	queues  []chan job
+	wg      sync.WaitGroup
+}
+
+type job struct {
+	handler func(msg *nats.Msg)
+	msg     *nats.Msg
+}
+
+// WorkerPoolOption sets an optional parameter for worker pools.
+type WorkerPoolOption func(*WorkerPool)
+
+// WorkerPoolConcurrency sets the number of messages served in parallel.
+// It defaults to 1.
+func WorkerPoolConcurrency(n int) WorkerPoolOption {
+	return func(p *WorkerPool) {
+		if n > 0 {
+			p.concurrency = n
+		}
+	}
+}
+
+// WorkerPoolPending sets the number of messages queued before ServeMsg
+// blocks. The queue is shared by all workers, unless WorkerPoolOrderedBy is
+// set, in which case every worker has a queue of that size. It defaults to 64.
+func WorkerPoolPending(n int) WorkerPoolOption {
+	return func(p *WorkerPool) {
+		if n >= 0 {
+			p.pending = n
+		}
+	}
+}
+
+// WorkerPoolOrderedBy serves messages sharing the same key one at a time and
+// in the order they were received, by always dispatching them to the same
+// worker.
+func WorkerPoolOrderedBy(key KeyFunc) WorkerPoolOption {
+	return func(p *WorkerPool) { p.key = key }
+}
+
+// NewWorkerPool returns a WorkerPool serving messages with h.
+func NewWorkerPool(h Handler, options ...WorkerPoolOption) *WorkerPool {
+	p := &WorkerPool{
+		next:        h,
+		concurrency: 1,
+		pending:     64,
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+// ServeMsg dispatches every message to the workers.
+//
+// Messages received once the pool is draining are served in place, unless
+// WorkerPoolOrderedBy is set: as they could overtake the messages of the same
+// key still queued, they are then rejected instead. Rejected JetStream
+// messages are nacked so that they are redelivered, others are dropped, so
+// drain the subscriptions before the pool, as SubscriptionSet does.
+func (p *WorkerPool) ServeMsg(nc *nats.Conn) func(msg *nats.Msg) {
+	p.once.Do(p.start)
+	handler := p.next.ServeMsg(nc)
+	return func(msg *nats.Msg) {
+		p.mu.RLock()
+		if p.closed {
+			p.mu.RUnlock()
+			if p.key == nil {
+				handler(msg)
+			} else if _, err := msg.Metadata(); err == nil {
+				msg.Nak()
+			}
+			return
+		}
+		p.senders.Add(1)
+		p.mu.RUnlock()
+
+		// the workers keep serving the queues while draining, so that messages
+		// blocked on a full queue are eventually queued in order
+		p.queues[p.queue(msg)] <- job{handler, msg}
+		p.senders.Done()
+	}
+}
+
+func (p *WorkerPool) start() {
+	queues := 1
+	if p.key != nil {
+		queues = p.concurrency
+	}
+	p.queues = make([]chan job, queues)
+	for i := range p.queues {
+		p.queues[i] = make(chan job, p.pending)
+	}
+	for i := 0; i < p.concurrency; i++ {
+		p.wg.Add(1)
+		go p.work(p.queues[i%queues])
+	}
+}
+
+func (p *WorkerPool) work(jobs <-chan job) {
+	defer p.wg.Done()
+	for j := range jobs {
+		j.handler(j.msg)
+	}
+}
+
+func (p *WorkerPool) queue(msg *nats.Msg) int {
+	if p.key == nil {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(p.key(msg)))
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// Drain stops queueing messages and waits until the queued and in-flight
+// messages are served or ctx is done. Messages blocked on full queues are
+// still queued and served.
+func (p *WorkerPool) Drain(ctx context.Context) error {
+	p.once.Do(p.start)
+
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		go func() {
+			// queues are closed once no ServeMsg call can send to them
+			p.senders.Wait()
+			for _, q := range p.queues {
+				close(q)
+			}
+		}()
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
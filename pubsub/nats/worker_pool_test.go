@@ -0,0 +1,144 @@
+package pubsubnats
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type handlerFunc func(msg *nats.Msg)
+
+func (f handlerFunc) ServeMsg(_ *nats.Conn) func(msg *nats.Msg) {
+	return f
+}
+
+func TestWorkerPool_BoundsConcurrency(t *testing.T) {
+	var inflight, peak int32
+	h := handlerFunc(func(msg *nats.Msg) {
+		n := atomic.AddInt32(&inflight, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&inflight, -1)
+	})
+
+	pool := NewWorkerPool(h, WorkerPoolConcurrency(3))
+	serve := pool.ServeMsg(nil)
+	for i := 0; i < 30; i++ {
+		serve(&nats.Msg{Subject: "orders.created"})
+	}
+	require.NoError(t, pool.Drain(context.Background()))
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&peak))
+}
+
+func TestWorkerPool_OrdersByKey(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string][]string{}
+	h := handlerFunc(func(msg *nats.Msg) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[msg.Subject] = append(received[msg.Subject], string(msg.Data))
+	})
+
+	pool := NewWorkerPool(h,
+		WorkerPoolConcurrency(4),
+		WorkerPoolOrderedBy(func(msg *nats.Msg) string { return msg.Subject }),
+	)
+	serve := pool.ServeMsg(nil)
+	for i := 0; i < 100; i++ {
+		serve(&nats.Msg{
+			Subject: fmt.Sprintf("orders.%d", i%5),
+			Data:    []byte(fmt.Sprint(i)),
+		})
+	}
+	require.NoError(t, pool.Drain(context.Background()))
+
+	for i := 0; i < 5; i++ {
+		var want []string
+		for j := i; j < 100; j += 5 {
+			want = append(want, fmt.Sprint(j))
+		}
+		assert.Equal(t, want, received[fmt.Sprintf("orders.%d", i)])
+	}
+}
+
+func TestWorkerPool_Drain(t *testing.T) {
+	release := make(chan struct{})
+	var served int32
+	h := handlerFunc(func(msg *nats.Msg) {
+		<-release
+		atomic.AddInt32(&served, 1)
+	})
+
+	pool := NewWorkerPool(h, WorkerPoolConcurrency(2))
+	serve := pool.ServeMsg(nil)
+	for i := 0; i < 4; i++ {
+		serve(&nats.Msg{Subject: "orders.created"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pool.Drain(ctx))
+
+	close(release)
+	require.NoError(t, pool.Drain(context.Background()))
+	assert.Equal(t, int32(4), atomic.LoadInt32(&served))
+
+	// messages arriving after the drain are still served
+	serve(&nats.Msg{Subject: "orders.created"})
+	assert.Equal(t, int32(5), atomic.LoadInt32(&served))
+}
+
+func TestWorkerPool_OrderedRejectsWhileDraining(t *testing.T) {
+	var served int32
+	h := handlerFunc(func(msg *nats.Msg) { atomic.AddInt32(&served, 1) })
+
+	pool := NewWorkerPool(h, WorkerPoolOrderedBy(func(msg *nats.Msg) string { return msg.Subject }))
+	serve := pool.ServeMsg(nil)
+	serve(&nats.Msg{Subject: "orders.created"})
+	require.NoError(t, pool.Drain(context.Background()))
+
+	// serving in place could overtake queued messages of the same key
+	serve(&nats.Msg{Subject: "orders.created"})
+	assert.Equal(t, int32(1), atomic.LoadInt32(&served))
+}
+
+func TestWorkerPool_DrainWithFullQueues(t *testing.T) {
+	release := make(chan struct{})
+	var served int32
+	h := handlerFunc(func(msg *nats.Msg) {
+		<-release
+		atomic.AddInt32(&served, 1)
+	})
+
+	pool := NewWorkerPool(h, WorkerPoolPending(1))
+	serve := pool.ServeMsg(nil)
+	serve(&nats.Msg{Subject: "orders.created"})
+	serve(&nats.Msg{Subject: "orders.created"})
+	blocked := make(chan struct{})
+	go func() {
+		serve(&nats.Msg{Subject: "orders.created"})
+		close(blocked)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, pool.Drain(ctx))
+
+	close(release)
+	<-blocked
+	require.NoError(t, pool.Drain(context.Background()))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&served))
+}
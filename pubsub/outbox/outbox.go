@@ -0,0 +1,162 @@
+// Package outbox implements the transactional outbox pattern on top of
+// database/sql.
+//
+// Events are recorded in the same transaction as the state changes they
+// describe, and a Relay publishes them afterwards through a pubsub.Publisher,
+// so that an event is never lost if the process dies between the commit and
+// the publish.
+//
+// Example:
+//
+//	tx, err := db.BeginTx(ctx, nil)
+//	...
+//	if err := ob.Record(ctx, tx, order.ID, OrderCreatedTopic, order.CreatedEvent{...}); err != nil {
+//		tx.Rollback()
+//		return err
+//	}
+//	return tx.Commit()
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/etherlabsio/errors"
+)
+
+// Schema creates the outbox table and its index in SQLite. Other databases
+// need an equivalent table with an auto-incrementing id.
+const Schema = `
+CREATE TABLE IF NOT EXISTS outbox (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	aggregate_key TEXT NOT NULL,
+	subject       TEXT NOT NULL,
+	payload       BLOB NOT NULL,
+	created_at    TIMESTAMP NOT NULL,
+	published_at  TIMESTAMP NULL
+);
+CREATE INDEX IF NOT EXISTS outbox_unpublished ON outbox (published_at, id);
+`
+
+// Event is an event recorded in the outbox.
+type Event struct {
+	ID        int64
+	Key       string
+	Subject   string
+	Payload   []byte
+	CreatedAt time.Time
+}
+
+// Execer is satisfied by *sql.Tx, and by *sql.DB when no transaction is
+// needed.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Outbox records events in the outbox table.
+type Outbox struct {
+	table       string
+	placeholder func(n int) string
+	marshal     func(v interface{}) ([]byte, error)
+	now         func() time.Time
+}
+
+// Option sets an optional parameter for the outbox.
+type Option func(*Outbox)
+
+// Table sets the name of the outbox table. It defaults to "outbox".
+func Table(name string) Option {
+	return func(o *Outbox) { o.table = name }
+}
+
+// DollarPlaceholders uses $1, $2... query placeholders, as required by
+// PostgreSQL, instead of ?.
+func DollarPlaceholders() Option {
+	return func(o *Outbox) {
+		o.placeholder = func(n int) string { return fmt.Sprintf("$%d", n) }
+	}
+}
+
+// Marshal sets the payload serializer. Payloads are relayed as
+// json.RawMessage, so it should produce JSON, which is the default.
+func Marshal(f func(v interface{}) ([]byte, error)) Option {
+	return func(o *Outbox) { o.marshal = f }
+}
+
+// New returns an Outbox.
+func New(opts ...Option) *Outbox {
+	o := &Outbox{
+		table:       "outbox",
+		placeholder: func(int) string { return "?" },
+		marshal:     json.Marshal,
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// Record stores msg for publishing to subject once tx commits. Events sharing
+// the same aggregate key are published in the order they are recorded.
+func (o *Outbox) Record(ctx context.Context, tx Execer, key, subject string, msg interface{}) error {
+	const op = errors.Op("outbox.Record")
+	payload, err := o.marshal(msg)
+	if err != nil {
+		return errors.New("marshal event for subject "+subject, err, op, errors.Invalid)
+	}
+	query := fmt.Sprintf(
+		"INSERT INTO %s (aggregate_key, subject, payload, created_at) VALUES (%s, %s, %s, %s)",
+		o.table, o.placeholder(1), o.placeholder(2), o.placeholder(3), o.placeholder(4),
+	)
+	if _, err := tx.ExecContext(ctx, query, key, subject, payload, o.now().UTC()); err != nil {
+		return errors.New("insert event for subject "+subject, err, op, errors.IO)
+	}
+	return nil
+}
+
+// pending returns up to limit unpublished events, in order, leaving out those
+// with the excluded aggregate keys.
+func (o *Outbox) pending(ctx context.Context, db *sql.DB, limit int, exclude ...string) ([]Event, error) {
+	var (
+		cond = "published_at IS NULL"
+		args = make([]interface{}, len(exclude))
+	)
+	if len(exclude) > 0 {
+		placeholders := make([]string, len(exclude))
+		for i, key := range exclude {
+			placeholders[i] = o.placeholder(i + 1)
+			args[i] = key
+		}
+		cond += " AND aggregate_key NOT IN (" + strings.Join(placeholders, ", ") + ")"
+	}
+	query := fmt.Sprintf(
+		"SELECT id, aggregate_key, subject, payload, created_at FROM %s WHERE %s ORDER BY id LIMIT %d",
+		o.table, cond, limit,
+	)
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.Key, &e.Subject, &e.Payload, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (o *Outbox) markPublished(ctx context.Context, db *sql.DB, id int64) error {
+	query := fmt.Sprintf("UPDATE %s SET published_at = %s WHERE id = %s", o.table, o.placeholder(1), o.placeholder(2))
+	_, err := db.ExecContext(ctx, query, o.now().UTC(), id)
+	return err
+}
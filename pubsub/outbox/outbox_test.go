@@ -0,0 +1,191 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/etherlabsio/pkg/locker"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type published struct {
+	subject string
+	payload string
+}
+
+type recordingPublisher struct {
+	published []published
+	fail      map[string]bool
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, subject string, msg interface{}) error {
+	if p.fail[subject] {
+		return errors.New("publish failure", errors.IO)
+	}
+	p.published = append(p.published, published{subject, string(msg.(json.RawMessage))})
+	return nil
+}
+
+func setup(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "outbox.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(Schema)
+	require.NoError(t, err)
+	return db
+}
+
+func TestOutbox_RecordInTransaction(t *testing.T) {
+	ctx := context.Background()
+	db := setup(t)
+	ob := New()
+
+	tx, err := db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, ob.Record(ctx, tx, "order-1", "order.created", map[string]string{"id": "order-1"}))
+	require.NoError(t, tx.Rollback())
+
+	tx, err = db.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	require.NoError(t, ob.Record(ctx, tx, "order-2", "order.created", map[string]string{"id": "order-2"}))
+	require.NoError(t, tx.Commit())
+
+	events, err := ob.pending(ctx, db, 10)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "order-2", events[0].Key)
+	assert.JSONEq(t, `{"id": "order-2"}`, string(events[0].Payload))
+}
+
+func TestRelay_PublishesInOrderPerKey(t *testing.T) {
+	ctx := context.Background()
+	db := setup(t)
+	ob := New()
+
+	for _, e := range []struct{ key, subject string }{
+		{"order-1", "order.created"},
+		{"order-2", "order.created"},
+		{"order-1", "payment.failed"},
+		{"order-1", "order.cancelled"},
+		{"order-2", "order.shipped"},
+	} {
+		require.NoError(t, ob.Record(ctx, db, e.key, e.subject, e.key))
+	}
+
+	p := &recordingPublisher{fail: map[string]bool{"payment.failed": true}}
+	relay := NewRelay(db, p)
+
+	n, err := relay.Relay(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []published{
+		{"order.created", `"order-1"`},
+		{"order.created", `"order-2"`},
+		{"order.shipped", `"order-2"`},
+	}, p.published, "events after a failure are held back for the same key only")
+
+	p.fail = nil
+	n, err = relay.Relay(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []published{
+		{"payment.failed", `"order-1"`},
+		{"order.cancelled", `"order-1"`},
+	}, p.published[3:])
+
+	n, err = relay.Relay(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestRelay_CompletesBatchWithOtherKeys(t *testing.T) {
+	ctx := context.Background()
+	db := setup(t)
+	ob := New()
+
+	for _, e := range []struct{ key, subject string }{
+		{"order-1", "payment.failed"},
+		{"order-1", "order.cancelled"},
+		{"order-1", "order.refunded"},
+		{"order-2", "order.created"},
+	} {
+		require.NoError(t, ob.Record(ctx, db, e.key, e.subject, e.key))
+	}
+
+	p := &recordingPublisher{fail: map[string]bool{"payment.failed": true}}
+	n, err := NewRelay(db, p, RelayBatch(3)).Relay(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []published{{"order.created", `"order-2"`}}, p.published)
+}
+
+type slowPublisher struct {
+	recordingPublisher
+	delay time.Duration
+}
+
+func (p *slowPublisher) Publish(ctx context.Context, subject string, msg interface{}) error {
+	time.Sleep(p.delay)
+	return p.recordingPublisher.Publish(ctx, subject, msg)
+}
+
+type nopLocker struct{}
+
+func (nopLocker) Lock(context.Context, string, ...locker.Option) (locker.Unlocker, error) {
+	return nopUnlocker{}, nil
+}
+
+type nopUnlocker struct{}
+
+func (nopUnlocker) Unlock() error { return nil }
+
+func TestRelay_StopsBeforeLockExpires(t *testing.T) {
+	ctx := context.Background()
+	db := setup(t)
+	ob := New()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, ob.Record(ctx, db, "order-1", "order.created", i))
+	}
+
+	p := &slowPublisher{delay: 20 * time.Millisecond}
+	n, err := NewRelay(db, p, RelayInterval(50*time.Millisecond), RelayLocker(nopLocker{}, "outbox")).Relay(ctx)
+	require.NoError(t, err)
+	assert.True(t, n > 0 && n < 10, "published %d events", n)
+}
+
+type deadlinePublisher struct {
+	recordingPublisher
+	deadlines []time.Time
+}
+
+func (p *deadlinePublisher) Publish(ctx context.Context, subject string, msg interface{}) error {
+	deadline, _ := ctx.Deadline()
+	p.deadlines = append(p.deadlines, deadline)
+	return p.recordingPublisher.Publish(ctx, subject, msg)
+}
+
+func TestRelay_PublishesWithinLockTTL(t *testing.T) {
+	ctx := context.Background()
+	db := setup(t)
+	require.NoError(t, New().Record(ctx, db, "order-1", "order.created", 1))
+
+	p := &deadlinePublisher{}
+	start := time.Now()
+	n, err := NewRelay(db, p, RelayInterval(time.Minute), RelayLocker(nopLocker{}, "outbox")).Relay(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	assert.False(t, p.deadlines[0].IsZero(), "publish is not bounded by the lock")
+	assert.WithinDuration(t, start.Add(2*time.Minute), p.deadlines[0], time.Second)
+}
+
+func TestRelayBatch_KeepsDefault(t *testing.T) {
+	r := NewRelay(nil, &recordingPublisher{}, RelayBatch(0))
+	assert.Equal(t, 100, r.batch)
+}
@@ -0,0 +1,180 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/etherlabsio/pkg/locker"
+	"github.com/etherlabsio/pkg/logutil"
+	"github.com/etherlabsio/pkg/pubsub"
+	"github.com/go-kit/kit/log"
+)
+
+// Relay publishes the events recorded in the outbox. Events are published at
+// least once: an event is published again if the relay stops before it is
+// marked as published.
+type Relay struct {
+	db        *sql.DB
+	outbox    *Outbox
+	publisher pubsub.Publisher
+	interval  time.Duration
+	batch     int
+	locker    locker.Locker
+	lockKey   string
+	logger    log.Logger
+}
+
+// RelayOption sets an optional parameter for the relay.
+type RelayOption func(*Relay)
+
+// RelayOutbox sets the outbox configuration, which must match the one used to
+// record the events.
+func RelayOutbox(o *Outbox) RelayOption {
+	return func(r *Relay) { r.outbox = o }
+}
+
+// RelayInterval sets the polling interval. It defaults to 1 second.
+func RelayInterval(d time.Duration) RelayOption {
+	return func(r *Relay) { r.interval = d }
+}
+
+// RelayBatch sets the maximum number of events published per poll. It
+// defaults to 100, which is kept if n is not positive.
+func RelayBatch(n int) RelayOption {
+	return func(r *Relay) {
+		if n > 0 {
+			r.batch = n
+		}
+	}
+}
+
+// RelayLocker makes replicas of the relay take turns through a distributed
+// lock, so that the same events are not published concurrently. A run stops
+// publishing once it has held the lock for one polling interval, and the lock
+// expires after two. Publishes are cancelled when the lock expires, so that
+// they complete before another replica takes over.
+func RelayLocker(l locker.Locker, key string) RelayOption {
+	return func(r *Relay) {
+		r.locker = l
+		r.lockKey = key
+	}
+}
+
+// RelayLogger sets the logger for publish failures.
+func RelayLogger(l log.Logger) RelayOption {
+	return func(r *Relay) { r.logger = log.With(l, "component", "outbox_relay") }
+}
+
+// NewRelay returns a Relay publishing the outbox events of db with p.
+func NewRelay(db *sql.DB, p pubsub.Publisher, opts ...RelayOption) *Relay {
+	r := &Relay{
+		db:        db,
+		outbox:    New(),
+		publisher: p,
+		interval:  time.Second,
+		batch:     100,
+		logger:    log.NewNopLogger(),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run relays the outbox events every interval until ctx is done.
+func (r *Relay) Run(ctx context.Context) error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Relay(ctx); err != nil {
+			logutil.WithError(r.logger, err).Log("msg", "outbox relay failure")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay publishes a batch of pending events in order and returns the number
+// of events published. Once publishing an event fails, the following events
+// with the same aggregate key are held back until the next run, and the batch
+// is completed with events of other keys.
+func (r *Relay) Relay(ctx context.Context) (int, error) {
+	const op = errors.Op("outbox.Relay")
+	var deadline, expiry time.Time
+	if r.locker != nil {
+		locked := time.Now()
+		unlocker, err := r.locker.Lock(ctx, r.lockKey, locker.WithTTL(2*r.interval))
+		if err == locker.ErrNotObtained {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, errors.WithOp(err, op)
+		}
+		defer unlocker.Unlock()
+		deadline = locked.Add(r.interval)
+		expiry = locked.Add(2 * r.interval)
+	}
+
+	var (
+		published, attempted int
+		blocked              []string
+	)
+	for attempted < r.batch {
+		limit := r.batch - attempted
+		events, err := r.outbox.pending(ctx, r.db, limit, blocked...)
+		if err != nil {
+			return published, errors.New("query pending events", err, op, errors.IO)
+		}
+
+		blockedBefore := len(blocked)
+		for _, e := range events {
+			if contains(blocked, e.Key) {
+				continue
+			}
+			if !deadline.IsZero() && time.Now().After(deadline) {
+				return published, nil
+			}
+			attempted++
+			if err := r.publish(ctx, e, expiry); err != nil {
+				blocked = append(blocked, e.Key)
+				logutil.WithError(r.logger, err).Log("msg", "event publish failure", "id", e.ID, "subject", e.Subject, "key", e.Key)
+				continue
+			}
+			if err := r.outbox.markPublished(ctx, r.db, e.ID); err != nil {
+				return published, errors.New("mark event as published", err, op, errors.IO)
+			}
+			published++
+		}
+		// the events held back took up part of the query limit, so other keys
+		// may have pending events left
+		if len(events) < limit || len(blocked) == blockedBefore {
+			break
+		}
+	}
+	return published, nil
+}
+
+// publish publishes e, cancelling it at expiry unless it is zero.
+func (r *Relay) publish(ctx context.Context, e Event, expiry time.Time) error {
+	if !expiry.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, expiry)
+		defer cancel()
+	}
+	return r.publisher.Publish(ctx, e.Subject, json.RawMessage(e.Payload))
+}
+
+func contains(keys []string, key string) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,379 @@
+package redisstream
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	goredis "github.com/go-redis/redis"
+
+	"github.com/etherlabsio/pkg/pubsub"
+	"github.com/etherlabsio/pkg/redis"
+)
+
+// Fields added to the entries moved to the dead-letter stream.
+const (
+	DeadLetterStreamField     = "Dead-Letter-Stream"
+	DeadLetterIDField         = "Dead-Letter-ID"
+	DeadLetterDeliveriesField = "Dead-Letter-Deliveries"
+)
+
+var _ pubsub.Subscriber = (*Consumer)(nil)
+
+// Consumer implements pubsub.Subscriber as a member of a consumer group.
+//
+// New entries are read with XREADGROUP and acknowledged with XACK once
+// handled. Entries left pending by a failed handler or a crashed consumer
+// are claimed with XCLAIM after they have been idle for MinIdle, and handled
+// again, until they have been delivered MaxDeliver times.
+type Consumer struct {
+	client     *redis.Client
+	group      string
+	name       string
+	batch      int64
+	block      time.Duration
+	minIdle    time.Duration
+	interval   time.Duration
+	maxDeliver int64
+	deadLetter string
+	logger     log.Logger
+}
+
+// ConsumerOption sets an optional parameter for consumers.
+type ConsumerOption func(*Consumer)
+
+// ConsumerBatch sets the maximum number of entries read at once. It defaults to 10.
+func ConsumerBatch(n int64) ConsumerOption {
+	return func(c *Consumer) { c.batch = n }
+}
+
+// ConsumerBlock sets how long a read waits for new entries. It defaults to 1 second.
+func ConsumerBlock(d time.Duration) ConsumerOption {
+	return func(c *Consumer) { c.block = d }
+}
+
+// ConsumerMinIdle sets how long an entry stays pending before it is claimed
+// again. It defaults to 1 minute.
+func ConsumerMinIdle(d time.Duration) ConsumerOption {
+	return func(c *Consumer) { c.minIdle = d }
+}
+
+// ConsumerClaimInterval sets how often pending entries are looked up. It
+// defaults to 30 seconds.
+func ConsumerClaimInterval(d time.Duration) ConsumerOption {
+	return func(c *Consumer) { c.interval = d }
+}
+
+// ConsumerMaxDeliver sets the number of deliveries after which a failing entry
+// is acknowledged and given up on, or moved to the dead-letter stream if any.
+// It defaults to 10, and entries are retried forever if n is not positive.
+func ConsumerMaxDeliver(n int64) ConsumerOption {
+	return func(c *Consumer) { c.maxDeliver = n }
+}
+
+// ConsumerDeadLetter adds the entries given up on after MaxDeliver deliveries
+// to stream, with their source stream, ID and number of deliveries.
+func ConsumerDeadLetter(stream string) ConsumerOption {
+	return func(c *Consumer) { c.deadLetter = stream }
+}
+
+func ConsumerLogger(l log.Logger) ConsumerOption {
+	return func(c *Consumer) { c.logger = log.With(l, "component", "messaging_consumer") }
+}
+
+// NewConsumer returns a Consumer named name in the consumer group. Names must
+// be unique within the group, and stable across restarts so that the entries
+// left pending are recovered first.
+func NewConsumer(client *redis.Client, group, name string, options ...ConsumerOption) *Consumer {
+	c := &Consumer{
+		client:     client,
+		group:      group,
+		name:       name,
+		batch:      10,
+		block:      time.Second,
+		minIdle:    time.Minute,
+		interval:   30 * time.Second,
+		maxDeliver: 10,
+		logger:     log.NewNopLogger(),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// Subscribe creates the consumer group of the stream if needed, and serves
+// its entries with h until the subscription is unsubscribed.
+func (c *Consumer) Subscribe(_ context.Context, stream string, h pubsub.Handler) (pubsub.Subscription, error) {
+	const op errors.Op = "redisstream.Consumer.Subscribe"
+	err := c.client.XGroupCreateMkStream(stream, c.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, errors.New("create group "+c.group+" for stream "+stream, err, op, errors.IO)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &subscription{
+		consumer: c,
+		stream:   stream,
+		handler:  h,
+		cancel:   cancel,
+		logger:   log.With(c.logger, "stream", stream, "group", c.group, "consumer", c.name),
+	}
+	sub.wg.Add(1)
+	go sub.run(ctx)
+	return sub, nil
+}
+
+type subscription struct {
+	consumer *Consumer
+	stream   string
+	handler  pubsub.Handler
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	logger   log.Logger
+}
+
+// Unsubscribe stops reading entries and waits for the in-flight ones.
+func (s *subscription) Unsubscribe() error {
+	s.cancel()
+	s.wg.Wait()
+	return nil
+}
+
+func (s *subscription) run(ctx context.Context) {
+	defer s.wg.Done()
+	c := s.consumer
+
+	// entries pending for this consumer since a previous run come first
+	s.read(ctx, "0")
+	lastClaim := time.Now()
+
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= c.interval {
+			s.claim(ctx)
+			lastClaim = time.Now()
+		}
+		if !s.read(ctx, ">") {
+			select {
+			case <-ctx.Done():
+			case <-time.After(c.block):
+			}
+		}
+	}
+}
+
+// read handles the entries returned by XREADGROUP from id and reports whether
+// the read succeeded.
+func (s *subscription) read(ctx context.Context, id string) bool {
+	c := s.consumer
+	block := c.block
+	if id != ">" {
+		block = -1
+	}
+	streams, err := c.client.XReadGroup(&goredis.XReadGroupArgs{
+		Group:    c.group,
+		Consumer: c.name,
+		Streams:  []string{s.stream, id},
+		Count:    c.batch,
+		Block:    block,
+	}).Result()
+	if err == goredis.Nil {
+		return true
+	}
+	if err != nil {
+		level.Error(s.logger).Log("msg", "stream read failure", "err", err)
+		return false
+	}
+	for _, stream := range streams {
+		for _, entry := range stream.Messages {
+			s.handle(ctx, entry)
+		}
+	}
+	return true
+}
+
+// claim takes over the entries which have been pending for too long and
+// handles them. Those already delivered MaxDeliver times are given up on. The
+// pending entries are paged through, so that recently delivered ones don't
+// hide the idle entries after them.
+func (s *subscription) claim(ctx context.Context) {
+	c := s.consumer
+	start := "-"
+	for ctx.Err() == nil {
+		pending, err := c.client.XPendingExt(&goredis.XPendingExtArgs{
+			Stream: s.stream,
+			Group:  c.group,
+			Start:  start,
+			End:    "+",
+			Count:  c.batch,
+		}).Result()
+		if err != nil {
+			level.Error(s.logger).Log("msg", "stream pending lookup failure", "err", err)
+			return
+		}
+		if len(pending) == 0 {
+			return
+		}
+		s.claimIdle(ctx, pending)
+		if int64(len(pending)) < c.batch {
+			return
+		}
+		if start, err = nextID(pending[len(pending)-1].Id); err != nil {
+			level.Error(s.logger).Log("msg", "stream pending lookup failure", "err", err)
+			return
+		}
+	}
+}
+
+// claimIdle claims and handles the idle entries of pending.
+func (s *subscription) claimIdle(ctx context.Context, pending []goredis.XPendingExt) {
+	c := s.consumer
+	var ids []string
+	for _, p := range pending {
+		if p.Idle < c.minIdle {
+			continue
+		}
+		if c.maxDeliver > 0 && p.RetryCount >= c.maxDeliver {
+			s.giveUp(p)
+			continue
+		}
+		ids = append(ids, p.Id)
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	entries, err := c.client.XClaim(&goredis.XClaimArgs{
+		Stream:   s.stream,
+		Group:    c.group,
+		Consumer: c.name,
+		MinIdle:  c.minIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		level.Error(s.logger).Log("msg", "stream claim failure", "err", err)
+		return
+	}
+	for _, entry := range entries {
+		s.handle(ctx, entry)
+	}
+}
+
+// nextID returns the entry ID following id, since ranges can only be made
+// exclusive from Redis 6.2 on.
+func nextID(id string) (string, error) {
+	const op errors.Op = "redisstream.nextID"
+	i := strings.IndexByte(id, '-')
+	if i < 0 {
+		return "", errors.New("invalid entry ID "+id, op, errors.Invalid)
+	}
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return "", errors.New("invalid entry ID "+id, err, op, errors.Invalid)
+	}
+	return id[:i+1] + strconv.FormatUint(seq+1, 10), nil
+}
+
+// giveUp acknowledges the pending entry p, and adds it to the dead-letter stream
+// if any in the same transaction.
+func (s *subscription) giveUp(p goredis.XPendingExt) {
+	c := s.consumer
+	logger := log.With(s.logger, "id", p.Id, "deliveries", p.RetryCount)
+
+	var values map[string]interface{}
+	if c.deadLetter != "" {
+		entries, err := c.client.XRange(s.stream, p.Id, p.Id).Result()
+		if err != nil {
+			level.Error(logger).Log("msg", "stream dead-letter read failure", "err", err)
+			return
+		}
+		if len(entries) > 0 {
+			values = entries[0].Values
+			values[DeadLetterStreamField] = s.stream
+			values[DeadLetterIDField] = p.Id
+			values[DeadLetterDeliveriesField] = strconv.FormatInt(p.RetryCount, 10)
+		}
+	}
+
+	_, err := c.client.TxPipelined(func(pipe goredis.Pipeliner) error {
+		if values != nil {
+			pipe.XAdd(&goredis.XAddArgs{Stream: c.deadLetter, ID: "*", Values: values})
+		}
+		pipe.XAck(s.stream, c.group, p.Id)
+		return nil
+	})
+	if err != nil {
+		level.Error(logger).Log("msg", "stream dead-letter failure", "err", err)
+		return
+	}
+	level.Warn(logger).Log("msg", "stream entry given up on", "dead_letter", c.deadLetter)
+}
+
+func (s *subscription) handle(ctx context.Context, entry goredis.XMessage) {
+	msg := &message{sub: s, entry: entry}
+	if err := s.handler.HandleMessage(ctx, msg); err != nil {
+		msg.Nak()
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		level.Error(s.logger).Log("msg", "stream ack failure", "id", entry.ID, "err", err)
+	}
+}
+
+// message adapts a stream entry to pubsub.Message.
+type message struct {
+	sub     *subscription
+	entry   goredis.XMessage
+	settled bool
+}
+
+func (m *message) Subject() string { return m.sub.stream }
+
+func (m *message) Data() []byte {
+	return toBytes(m.entry.Values[DataField])
+}
+
+func (m *message) Header() map[string][]string {
+	h := map[string][]string{}
+	for k, v := range m.entry.Values {
+		if k != DataField {
+			h[k] = []string{string(toBytes(v))}
+		}
+	}
+	return h
+}
+
+// Ack acknowledges the entry with XACK.
+func (m *message) Ack() error {
+	if m.settled {
+		return nil
+	}
+	m.settled = true
+	c := m.sub.consumer
+	return c.client.XAck(m.sub.stream, c.group, m.entry.ID).Err()
+}
+
+// Nak leaves the entry pending, so that it is claimed again after MinIdle.
+func (m *message) Nak() error {
+	m.settled = true
+	return nil
+}
+
+func toBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	case nil:
+		return nil
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,95 @@
+// Package redisstream implements the pubsub interfaces on Redis Streams, for
+// deployments which have Redis but no NATS.
+//
+// Messages are stream entries with the encoded payload in the "data" field.
+// Any other field of an entry is exposed as a message header.
+package redisstream
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	goredis "github.com/go-redis/redis"
+
+	"github.com/etherlabsio/pkg/pubsub"
+	"github.com/etherlabsio/pkg/redis"
+)
+
+// DataField is the stream entry field holding the message payload.
+const DataField = "data"
+
+var _ pubsub.Publisher = (*Publisher)(nil)
+
+// EncodeRequestFunc encodes the payload of a published message.
+type EncodeRequestFunc func(ctx context.Context, request interface{}) ([]byte, error)
+
+// EncodeJSONRequest is an EncodeRequestFunc serializing the request as JSON.
+func EncodeJSONRequest(_ context.Context, request interface{}) ([]byte, error) {
+	return json.Marshal(request)
+}
+
+// Publisher implements pubsub.Publisher by adding entries to streams.
+type Publisher struct {
+	client *redis.Client
+	enc    EncodeRequestFunc
+	maxLen int64
+	logger log.Logger
+}
+
+// PublisherOption sets an optional parameter for publishers.
+type PublisherOption func(*Publisher)
+
+// PublisherEncoder sets the payload encoder. It defaults to EncodeJSONRequest.
+func PublisherEncoder(enc EncodeRequestFunc) PublisherOption {
+	return func(p *Publisher) { p.enc = enc }
+}
+
+// PublisherMaxLen trims the streams to approximately n entries on every
+// publish. By default streams are not trimmed.
+func PublisherMaxLen(n int64) PublisherOption {
+	return func(p *Publisher) { p.maxLen = n }
+}
+
+func PublisherLogger(l log.Logger) PublisherOption {
+	return func(p *Publisher) { p.logger = log.With(l, "component", "messaging_publisher") }
+}
+
+// NewPublisher constructs a Publisher adding entries with client.
+func NewPublisher(client *redis.Client, options ...PublisherOption) *Publisher {
+	p := &Publisher{
+		client: client,
+		enc:    EncodeJSONRequest,
+		logger: log.NewNopLogger(),
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+// Publish adds msg to the stream named by key.
+func (p *Publisher) Publish(ctx context.Context, key string, msg interface{}) error {
+	const op = "redisstream.Publish"
+	data, err := p.enc(ctx, msg)
+	if err != nil {
+		return errors.Errorf("%s: encoder failure for stream %s and payload %+v", op, key, msg)
+	}
+
+	err = p.client.XAdd(&goredis.XAddArgs{
+		Stream:       key,
+		MaxLenApprox: p.maxLen,
+		Values:       map[string]interface{}{DataField: data},
+	}).Err()
+	if err != nil {
+		level.Error(p.logger).Log(
+			"topic", key,
+			"status", "failed",
+			"err", err,
+		)
+		return errors.Errorf("%s: publish failure for stream %s", op, key)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package redisstream
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	goredis "github.com/go-redis/redis"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/etherlabsio/pkg/pubsub"
+	"github.com/etherlabsio/pkg/redis/redistest"
+)
+
+func TestPublisher_MaxLen(t *testing.T) {
+	client := redistest.NewClient(t)
+	p := NewPublisher(client, PublisherMaxLen(2))
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, p.Publish(context.Background(), "orders", i))
+	}
+
+	entries, err := client.XRange("orders", "-", "+").Result()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "4", entries[1].Values[DataField])
+}
+
+func TestConsumer_RecoversPendingEntries(t *testing.T) {
+	client := redistest.NewClient(t)
+
+	var mu sync.Mutex
+	var handled []int
+	failed := false
+	done := make(chan struct{})
+	e := func(_ context.Context, request interface{}) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		n := request.(int)
+		if n == 1 && !failed {
+			failed = true
+			return nil, errors.Str("transient failure")
+		}
+		handled = append(handled, n)
+		if len(handled) == 3 {
+			close(done)
+		}
+		return nil, nil
+	}
+	dec := func(_ context.Context, msg pubsub.Message) (interface{}, error) {
+		var n int
+		err := json.Unmarshal(msg.Data(), &n)
+		return n, err
+	}
+
+	c := NewConsumer(client, "workers", "worker-1",
+		ConsumerBlock(10*time.Millisecond),
+		ConsumerMinIdle(20*time.Millisecond),
+		ConsumerClaimInterval(10*time.Millisecond),
+	)
+	sub, err := c.Subscribe(context.Background(), "orders", NewSubscriber(e, dec))
+	require.NoError(t, err)
+
+	p := NewPublisher(client)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, p.Publish(context.Background(), "orders", i))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending entry was not recovered")
+	}
+	require.NoError(t, sub.Unsubscribe())
+
+	assert.Equal(t, []int{0, 2, 1}, handled)
+	pending, err := client.XPending("orders", "workers").Result()
+	require.NoError(t, err)
+	assert.Zero(t, pending.Count)
+}
+
+func TestConsumer_SharesGroup(t *testing.T) {
+	client := redistest.NewClient(t)
+
+	var mu sync.Mutex
+	handled := map[string]int{}
+	var wg sync.WaitGroup
+	wg.Add(10)
+	h := pubsub.HandlerFunc(func(_ context.Context, msg pubsub.Message) error {
+		mu.Lock()
+		handled[string(msg.Data())]++
+		mu.Unlock()
+		wg.Done()
+		return nil
+	})
+
+	for _, name := range []string{"worker-1", "worker-2"} {
+		c := NewConsumer(client, "workers", name, ConsumerBlock(10*time.Millisecond))
+		sub, err := c.Subscribe(context.Background(), "orders", h)
+		require.NoError(t, err)
+		defer sub.Unsubscribe()
+	}
+
+	p := NewPublisher(client)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, p.Publish(context.Background(), "orders", i))
+	}
+	wg.Wait()
+
+	assert.Len(t, handled, 10)
+	for data, n := range handled {
+		assert.Equal(t, 1, n, "entry %s handled more than once", data)
+	}
+}
+
+func TestConsumer_DeadLetter(t *testing.T) {
+	client := redistest.NewClient(t)
+
+	var mu sync.Mutex
+	deliveries := 0
+	h := pubsub.HandlerFunc(func(context.Context, pubsub.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		deliveries++
+		return errors.Str("permanent failure")
+	})
+
+	c := NewConsumer(client, "workers", "worker-1",
+		ConsumerBlock(10*time.Millisecond),
+		ConsumerMinIdle(20*time.Millisecond),
+		ConsumerClaimInterval(10*time.Millisecond),
+		ConsumerMaxDeliver(3),
+		ConsumerDeadLetter("orders.dlq"),
+	)
+	sub, err := c.Subscribe(context.Background(), "orders", h)
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	require.NoError(t, NewPublisher(client).Publish(context.Background(), "orders", 1))
+
+	var entries []goredis.XMessage
+	require.Eventually(t, func() bool {
+		entries, err = client.XRange("orders.dlq", "-", "+").Result()
+		return err == nil && len(entries) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, sub.Unsubscribe())
+
+	assert.Equal(t, "1", entries[0].Values[DataField])
+	assert.Equal(t, "orders", entries[0].Values[DeadLetterStreamField])
+	assert.Equal(t, "3", entries[0].Values[DeadLetterDeliveriesField])
+	mu.Lock()
+	assert.Equal(t, 3, deliveries)
+	mu.Unlock()
+	pending, err := client.XPending("orders", "workers").Result()
+	require.NoError(t, err)
+	assert.Zero(t, pending.Count)
+}
+
+func TestConsumer_ClaimPagesThroughPending(t *testing.T) {
+	client := redistest.NewClient(t)
+	require.NoError(t, client.XGroupCreateMkStream("orders", "workers", "0").Err())
+	p := NewPublisher(client)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, p.Publish(context.Background(), "orders", i))
+	}
+
+	// all the entries are left pending by a crashed consumer, and the first
+	// ones have just been claimed by a busy one
+	streams, err := client.XReadGroup(&goredis.XReadGroupArgs{
+		Group: "workers", Consumer: "crashed", Streams: []string{"orders", ">"}, Block: -1,
+	}).Result()
+	require.NoError(t, err)
+	entries := streams[0].Messages
+	time.Sleep(30 * time.Millisecond)
+	require.NoError(t, client.XClaim(&goredis.XClaimArgs{
+		Stream: "orders", Group: "workers", Consumer: "busy", Messages: []string{entries[0].ID, entries[1].ID},
+	}).Err())
+
+	var handled []string
+	h := pubsub.HandlerFunc(func(_ context.Context, msg pubsub.Message) error {
+		handled = append(handled, string(msg.Data()))
+		return nil
+	})
+	c := NewConsumer(client, "workers", "worker-1", ConsumerBatch(2), ConsumerMinIdle(20*time.Millisecond))
+	sub := &subscription{consumer: c, stream: "orders", handler: h, logger: c.logger}
+	sub.claim(context.Background())
+
+	assert.Equal(t, []string{"2", "3"}, handled)
+}
@@ -0,0 +1,92 @@
+package redisstream
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+
+	"github.com/etherlabsio/pkg/pubsub"
+)
+
+// DecodeRequestFunc extracts a user-domain request object from a message.
+type DecodeRequestFunc func(context.Context, pubsub.Message) (interface{}, error)
+
+// RequestFunc may take information from a message and put it into the
+// context before it is decoded.
+type RequestFunc func(context.Context, pubsub.Message) context.Context
+
+// Subscriber wraps an endpoint and provides a pubsub.Handler.
+type Subscriber struct {
+	e      endpoint.Endpoint
+	dec    DecodeRequestFunc
+	before []RequestFunc
+	logger log.Logger
+}
+
+// NewSubscriber constructs a new subscriber, which provides a pubsub.Handler
+// wrapping the provided endpoint. Panics are recovered as errors, leaving the
+// message pending.
+func NewSubscriber(
+	e endpoint.Endpoint,
+	dec DecodeRequestFunc,
+	options ...SubscriberOption,
+) pubsub.Handler {
+	s := &Subscriber{
+		e:      e,
+		dec:    dec,
+		logger: log.NewNopLogger(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return pubsub.Recovery()(s)
+}
+
+// SubscriberOption sets an optional parameter for subscribers.
+type SubscriberOption func(*Subscriber)
+
+// SubscriberBefore functions are executed on the message before it is decoded.
+func SubscriberBefore(before ...RequestFunc) SubscriberOption {
+	return func(s *Subscriber) { s.before = append(s.before, before...) }
+}
+
+// SubscriberErrorLogger is used to log decoding and endpoint errors.
+func SubscriberErrorLogger(logger log.Logger) SubscriberOption {
+	return func(s *Subscriber) { s.logger = log.With(level.Error(logger), "component", "messaging_subscriber") }
+}
+
+// HandleMessage decodes msg and invokes the endpoint. Errors leave the message
+// pending, so that it is claimed again later.
+func (s Subscriber) HandleMessage(ctx context.Context, msg pubsub.Message) error {
+	logger := log.With(s.logger, "subject", msg.Subject())
+
+	for _, f := range s.before {
+		ctx = f(ctx, msg)
+	}
+
+	request, err := s.dec(ctx, msg)
+	if err != nil {
+		logger.Log(
+			"msg", "error decoding stream msg",
+			"err", err,
+		)
+		return err
+	}
+
+	if _, err := s.e(ctx, request); err != nil {
+		logger.Log(
+			"msg", "endpoint error for stream msg",
+			"err", err,
+		)
+		return err
+	}
+	return nil
+}
+
+// NopRequestDecoder is a DecodeRequestFunc that can be used for requests that do not
+// need to be decoded, and simply returns nil, nil.
+func NopRequestDecoder(_ context.Context, _ pubsub.Message) (interface{}, error) {
+	return nil, nil
+}
@@ -0,0 +1,443 @@
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	goredis "github.com/go-redis/redis"
+	"github.com/nats-io/nats.go"
+
+	"github.com/etherlabsio/pkg/pubsub"
+	pubsubnats "github.com/etherlabsio/pkg/pubsub/nats"
+	"github.com/etherlabsio/pkg/redis"
+)
+
+// maxTxRetries bounds the optimistic transactions retried on concurrent
+// updates of a saga.
+const maxTxRetries = 10
+
+// Orchestrator drives the sagas of a Definition. Replicas sharing the same
+// Redis can run concurrently: state transitions are optimistic transactions,
+// so every reply or timeout is applied once.
+//
+// Commands are published after the state is persisted. Commands of steps
+// without a timeout and compensations are published at least once: they are
+// retried by Run until the step is replied or the saga is aborted, so services
+// should be idempotent.
+type Orchestrator struct {
+	def       Definition
+	client    *redis.Client
+	publisher pubsub.Publisher
+
+	prefix    string
+	retention time.Duration
+	interval  time.Duration
+	resend    time.Duration
+	retry     time.Duration
+	logger    log.Logger
+}
+
+// Option sets an optional parameter for the orchestrator.
+type Option func(*Orchestrator)
+
+// KeyPrefix sets the prefix of the Redis keys. It defaults to "saga".
+func KeyPrefix(prefix string) Option {
+	return func(o *Orchestrator) { o.prefix = prefix }
+}
+
+// Retention sets how long completed and aborted sagas are kept. It defaults to
+// 24 hours.
+func Retention(d time.Duration) Option {
+	return func(o *Orchestrator) { o.retention = d }
+}
+
+// CheckInterval sets how often Run looks for timed out steps. It defaults to
+// 1 second.
+func CheckInterval(d time.Duration) Option {
+	return func(o *Orchestrator) { o.interval = d }
+}
+
+// CommandRetry sets the delay after which Run publishes again the command of
+// a step without timeout which has not been replied. It defaults to 30
+// seconds.
+func CommandRetry(d time.Duration) Option {
+	return func(o *Orchestrator) { o.resend = d }
+}
+
+// CompensationRetry sets the delay after which Run publishes again the
+// compensations of a saga that could not be aborted. It defaults to 30
+// seconds.
+func CompensationRetry(d time.Duration) Option {
+	return func(o *Orchestrator) { o.retry = d }
+}
+
+// Logger sets the logger for the failures of Run.
+func Logger(l log.Logger) Option {
+	return func(o *Orchestrator) { o.logger = log.With(l, "component", "saga", "saga", o.def.Name) }
+}
+
+// New returns an Orchestrator for def, keeping the state in client and
+// publishing the commands with publisher.
+func New(def Definition, client *redis.Client, publisher pubsub.Publisher, opts ...Option) (*Orchestrator, error) {
+	const op errors.Op = "saga.New"
+	if err := def.validate(); err != nil {
+		return nil, errors.WithOp(err, op)
+	}
+	o := &Orchestrator{
+		def:       def,
+		client:    client,
+		publisher: publisher,
+		prefix:    "saga",
+		retention: 24 * time.Hour,
+		interval:  time.Second,
+		resend:    30 * time.Second,
+		retry:     30 * time.Second,
+		logger:    log.NewNopLogger(),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o, nil
+}
+
+func (o *Orchestrator) key(id string) string {
+	return o.prefix + ":" + o.def.Name + ":state:" + id
+}
+
+func (o *Orchestrator) deadlinesKey() string {
+	return o.prefix + ":" + o.def.Name + ":deadlines"
+}
+
+// Start starts the saga id with data, publishing the command of the first
+// step. It fails with errors.AlreadyExist if the saga was already started.
+func (o *Orchestrator) Start(ctx context.Context, id string, data interface{}) error {
+	const op errors.Op = "saga.Orchestrator.Start"
+	b, err := json.Marshal(data)
+	if err != nil {
+		return errors.New("encode saga data", err, op, errors.Invalid)
+	}
+	s := State{ID: id, Saga: o.def.Name, Status: Running, Data: b}
+	o.enter(&s, time.Now())
+
+	key := o.key(id)
+	err = o.client.Watch(func(tx *goredis.Tx) error {
+		n, err := tx.Exists(key).Result()
+		if err != nil {
+			return errors.New("get saga "+id, err, op, errors.IO)
+		}
+		if n > 0 {
+			return errors.New("saga "+id+" already started", op, errors.AlreadyExist)
+		}
+		if _, err := tx.TxPipelined(func(pipe goredis.Pipeliner) error { return o.save(pipe, &s) }); err != nil {
+			if err == goredis.TxFailedErr {
+				return errors.New("saga "+id+" already started", op, errors.AlreadyExist)
+			}
+			return errors.New("save saga "+id, err, op, errors.IO)
+		}
+		return nil
+	}, key)
+	if err != nil {
+		return err
+	}
+	return o.dispatch(ctx, s)
+}
+
+// Get returns the state of the saga id. It fails with errors.NotExist if the
+// saga is unknown or expired.
+func (o *Orchestrator) Get(_ context.Context, id string) (State, error) {
+	const op errors.Op = "saga.Orchestrator.Get"
+	var s State
+	b, err := o.client.Get(o.key(id)).Bytes()
+	if err == goredis.Nil {
+		return s, errors.New("saga "+id+" not found", op, errors.NotExist)
+	}
+	if err != nil {
+		return s, errors.New("get saga "+id, err, op, errors.IO)
+	}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return s, errors.New("decode saga "+id, err, op, errors.Internal)
+	}
+	return s, nil
+}
+
+// Handle applies the reply of a step. Replies to steps other than the current
+// one, such as redeliveries, are ignored.
+func (o *Orchestrator) Handle(ctx context.Context, r Reply) error {
+	s, changed, err := o.transition(r.SagaID, func(s *State) bool {
+		if s.Status != Running || s.Step != r.Step {
+			return false
+		}
+		if r.Error != "" {
+			o.fail(s, fmt.Sprintf("step %s failed: %s", o.def.Steps[s.Step].Name, r.Error))
+			return true
+		}
+		if len(r.Data) > 0 {
+			s.Data = r.Data
+		}
+		s.Step++
+		if s.Step == len(o.def.Steps) {
+			s.Status = Completed
+			s.Deadline = time.Time{}
+			return true
+		}
+		o.enter(s, time.Now())
+		return true
+	})
+	if err != nil || !changed {
+		return err
+	}
+	return o.dispatch(ctx, s)
+}
+
+// Handler returns a NATS handler for the reply subject, calling Handle with
+// the decoded replies.
+func (o *Orchestrator) Handler(opts ...pubsubnats.SubscriberOption) pubsubnats.Handler {
+	return pubsubnats.NewSubscriber(
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			return nil, o.Handle(ctx, request.(Reply))
+		},
+		decodeReply,
+		opts...,
+	)
+}
+
+func decodeReply(_ context.Context, msg *nats.Msg) (interface{}, error) {
+	var r Reply
+	if err := json.Unmarshal(msg.Data, &r); err != nil {
+		return nil, errors.WithKind(err, errors.Invalid, "decode saga reply")
+	}
+	return r, nil
+}
+
+// Run fails the steps which timed out, and retries the commands of the steps
+// without timeout and the pending compensations, every check interval until
+// ctx is done.
+func (o *Orchestrator) Run(ctx context.Context) error {
+	ticker := time.NewTicker(o.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := o.check(ctx); err != nil {
+				level.Error(o.logger).Log("msg", "saga check failed", "err", err)
+			}
+		}
+	}
+}
+
+func (o *Orchestrator) check(ctx context.Context) error {
+	const op errors.Op = "saga.Orchestrator.check"
+	now := time.Now()
+	ids, err := o.client.ZRangeByScore(o.deadlinesKey(), goredis.ZRangeBy{
+		Min: "-inf",
+		Max: strconv.FormatInt(now.UnixMilli(), 10),
+	}).Result()
+	if err != nil {
+		return errors.New("get saga deadlines", err, op, errors.IO)
+	}
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := o.expire(ctx, id, now); err != nil {
+			level.Error(o.logger).Log("msg", "saga timeout failed", "id", id, "err", err)
+		}
+	}
+	return nil
+}
+
+// expire fails the current step of the saga id if it timed out, or publishes
+// again its command if it has no timeout, or its compensations.
+func (o *Orchestrator) expire(ctx context.Context, id string, now time.Time) error {
+	var resend bool
+	s, changed, err := o.transition(id, func(s *State) bool {
+		resend = false
+		if s.Deadline.IsZero() || s.Deadline.After(now) {
+			return false
+		}
+		switch s.Status {
+		case Running:
+			step := o.def.Steps[s.Step]
+			if step.Timeout > 0 {
+				o.fail(s, fmt.Sprintf("step %s timed out", step.Name))
+				break
+			}
+			s.Deadline = now.Add(o.resend)
+			resend = true
+		case Compensating:
+			s.Deadline = now.Add(o.retry)
+		default:
+			return false
+		}
+		return true
+	})
+	if errors.IsKind(err, errors.NotExist) {
+		return o.client.ZRem(o.deadlinesKey(), id).Err()
+	}
+	if err != nil || !changed {
+		return err
+	}
+	if resend {
+		// failing to publish again is retried by the next check
+		return o.publishCommand(ctx, s)
+	}
+	return o.dispatch(ctx, s)
+}
+
+// enter sets the deadline of the current step, after which it times out or its
+// command is published again.
+func (o *Orchestrator) enter(s *State, now time.Time) {
+	d := o.def.Steps[s.Step].Timeout
+	if d <= 0 {
+		d = o.resend
+	}
+	s.Deadline = now.Add(d)
+}
+
+// fail fails the current step, compensating the completed ones if any.
+func (o *Orchestrator) fail(s *State, reason string) {
+	s.Error = reason
+	for _, step := range o.def.Steps[:s.Step] {
+		if step.Compensation != "" {
+			s.Status = Compensating
+			s.Deadline = time.Now().Add(o.retry)
+			return
+		}
+	}
+	s.Status = Aborted
+	s.Deadline = time.Time{}
+}
+
+// dispatch publishes the commands due in state s.
+func (o *Orchestrator) dispatch(ctx context.Context, s State) error {
+	const op errors.Op = "saga.Orchestrator.dispatch"
+	switch s.Status {
+	case Running:
+		err := o.publishCommand(ctx, s)
+		if err == nil {
+			return nil
+		}
+		err = errors.WithOp(err, op)
+		failed, changed, _ := o.transition(s.ID, func(cur *State) bool {
+			if cur.Status != Running || cur.Step != s.Step {
+				return false
+			}
+			o.fail(cur, err.Error())
+			return true
+		})
+		if changed {
+			o.dispatch(ctx, failed)
+		}
+		return err
+
+	case Compensating:
+		for i := s.Step - 1; i >= 0; i-- {
+			step := o.def.Steps[i]
+			if step.Compensation == "" {
+				continue
+			}
+			err := o.publisher.Publish(ctx, step.Compensation, Command{
+				SagaID: s.ID,
+				Saga:   s.Saga,
+				Step:   i,
+				Data:   s.Data,
+			})
+			if err != nil {
+				return errors.New("publish compensation to "+step.Compensation, err, op, errors.IO)
+			}
+		}
+		_, _, err := o.transition(s.ID, func(cur *State) bool {
+			if cur.Status != Compensating {
+				return false
+			}
+			cur.Status = Aborted
+			cur.Deadline = time.Time{}
+			return true
+		})
+		return err
+	}
+	return nil
+}
+
+// publishCommand publishes the command of the current step of s.
+func (o *Orchestrator) publishCommand(ctx context.Context, s State) error {
+	const op errors.Op = "saga.Orchestrator.publishCommand"
+	step := o.def.Steps[s.Step]
+	err := o.publisher.Publish(ctx, step.Command, Command{
+		SagaID:  s.ID,
+		Saga:    s.Saga,
+		Step:    s.Step,
+		ReplyTo: o.def.ReplySubject,
+		Data:    s.Data,
+	})
+	if err != nil {
+		return errors.New("publish command to "+step.Command, err, op, errors.IO)
+	}
+	return nil
+}
+
+// transition applies f to the state of the saga id in an optimistic
+// transaction. f reports whether it changed the state.
+func (o *Orchestrator) transition(id string, f func(*State) bool) (State, bool, error) {
+	const op errors.Op = "saga.Orchestrator.transition"
+	key := o.key(id)
+	var (
+		s       State
+		changed bool
+	)
+	txf := func(tx *goredis.Tx) error {
+		b, err := tx.Get(key).Bytes()
+		if err == goredis.Nil {
+			return errors.New("saga "+id+" not found", op, errors.NotExist)
+		}
+		if err != nil {
+			return errors.New("get saga "+id, err, op, errors.IO)
+		}
+		s = State{}
+		if err := json.Unmarshal(b, &s); err != nil {
+			return errors.New("decode saga "+id, err, op, errors.Internal)
+		}
+		if changed = f(&s); !changed {
+			return nil
+		}
+		_, err = tx.TxPipelined(func(pipe goredis.Pipeliner) error { return o.save(pipe, &s) })
+		if err != nil && err != goredis.TxFailedErr {
+			return errors.New("save saga "+id, err, op, errors.IO)
+		}
+		return err
+	}
+	for i := 0; i < maxTxRetries; i++ {
+		if err := o.client.Watch(txf, key); err != goredis.TxFailedErr {
+			return s, changed, err
+		}
+	}
+	return s, false, errors.New("saga "+id+" updated concurrently", op, errors.IO)
+}
+
+// save queues the commands persisting s in pipe.
+func (o *Orchestrator) save(pipe goredis.Pipeliner, s *State) error {
+	s.UpdatedAt = time.Now()
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	var ttl time.Duration
+	if s.Status == Completed || s.Status == Aborted {
+		ttl = o.retention
+	}
+	pipe.Set(o.key(s.ID), b, ttl)
+	if s.Deadline.IsZero() {
+		pipe.ZRem(o.deadlinesKey(), s.ID)
+	} else {
+		pipe.ZAdd(o.deadlinesKey(), goredis.Z{Score: float64(s.Deadline.UnixMilli()), Member: s.ID})
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package saga orchestrates long-running processes spanning several services
+// as a sequence of steps with compensations.
+//
+// The Orchestrator persists the state of every saga in Redis, publishes the
+// command of the current step through a pubsub.Publisher and advances when the
+// service replies on the reply subject of the saga. When a step fails or times
+// out, the compensations of the completed steps are published in reverse order
+// and the saga is aborted.
+//
+// Example:
+//
+//	def := saga.Definition{
+//		Name:         "order",
+//		ReplySubject: "order.saga.reply",
+//		Steps: []saga.Step{
+//			{Name: "reserve", Command: "stock.reserve", Compensation: "stock.release", Timeout: time.Minute},
+//			{Name: "charge", Command: "payment.charge", Compensation: "payment.refund", Timeout: time.Minute},
+//			{Name: "ship", Command: "shipping.schedule"},
+//		},
+//	}
+//	o, err := saga.New(def, client, publisher)
+//	...
+//	nc.Subscribe(def.ReplySubject, o.Handler().ServeMsg(nc))
+//	go o.Run(ctx)
+//
+//	err = o.Start(ctx, order.ID, order)
+//
+// Services receive a Command, decoded with DecodeCommand, and answer with
+// Respond.
+package saga
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/nats-io/nats.go"
+
+	"github.com/etherlabsio/pkg/pubsub"
+)
+
+// Definition describes a saga.
+type Definition struct {
+	// Name identifies the saga in the Redis keys and commands.
+	Name string
+	// ReplySubject is the subject services reply to.
+	ReplySubject string
+	// Steps are executed in order.
+	Steps []Step
+}
+
+// Step is a unit of work performed by a service.
+type Step struct {
+	Name string
+	// Command is the subject the command of the step is published to.
+	Command string
+	// Compensation is the subject the command undoing the step is published
+	// to when a later step fails. Steps without one are not compensated.
+	Compensation string
+	// Timeout is how long to wait for the reply before failing the step. Zero
+	// means no timeout: the command is published again every CommandRetry
+	// until the step is replied.
+	Timeout time.Duration
+}
+
+func (d Definition) validate() error {
+	const op errors.Op = "saga.Definition.validate"
+	if d.Name == "" {
+		return errors.New("saga name required", op, errors.Invalid)
+	}
+	if d.ReplySubject == "" {
+		return errors.New("reply subject required for saga "+d.Name, op, errors.Invalid)
+	}
+	if len(d.Steps) == 0 {
+		return errors.New("no steps for saga "+d.Name, op, errors.Invalid)
+	}
+	for _, s := range d.Steps {
+		if s.Command == "" {
+			return errors.New("command required for step "+s.Name+" of saga "+d.Name, op, errors.Invalid)
+		}
+		if s.Timeout < 0 {
+			return errors.New("negative timeout for step "+s.Name+" of saga "+d.Name, op, errors.Invalid)
+		}
+	}
+	return nil
+}
+
+// Status is the status of a saga.
+type Status string
+
+// Saga statuses.
+const (
+	Running      Status = "running"
+	Compensating Status = "compensating"
+	Completed    Status = "completed"
+	Aborted      Status = "aborted"
+)
+
+// State is the persisted state of a saga.
+type State struct {
+	ID     string `json:"id"`
+	Saga   string `json:"saga"`
+	Status Status `json:"status"`
+	// Step is the index of the current step, or of the failed step once
+	// compensating or aborted.
+	Step int `json:"step"`
+	// Data is the payload of the saga, replaced by the data of every
+	// successful reply.
+	Data json.RawMessage `json:"data,omitempty"`
+	// Error is the reason the saga was aborted.
+	Error string `json:"error,omitempty"`
+	// Deadline is when the current step times out.
+	Deadline  time.Time `json:"deadline"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Command asks a service to perform, or compensate, a step.
+type Command struct {
+	SagaID  string          `json:"saga_id"`
+	Saga    string          `json:"saga"`
+	Step    int             `json:"step"`
+	ReplyTo string          `json:"reply_to,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Reply is the outcome of a step, sent by the service to the ReplyTo subject
+// of the command.
+type Reply struct {
+	SagaID string          `json:"saga_id"`
+	Step   int             `json:"step"`
+	Error  string          `json:"error,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// DecodeCommand is a DecodeRequestFunc for the subscribers of services,
+// decoding the message into a Command.
+func DecodeCommand(_ context.Context, msg *nats.Msg) (interface{}, error) {
+	var cmd Command
+	if err := json.Unmarshal(msg.Data, &cmd); err != nil {
+		return nil, errors.WithKind(err, errors.Invalid, "decode saga command")
+	}
+	return cmd, nil
+}
+
+// Respond publishes the outcome of cmd to its ReplyTo subject. A non-nil err
+// fails the step, otherwise data, if not nil, becomes the data of the saga.
+// Compensation commands have no ReplyTo, for which Respond does nothing.
+func Respond(ctx context.Context, publisher pubsub.Publisher, cmd Command, data interface{}, err error) error {
+	const op errors.Op = "saga.Respond"
+	if cmd.ReplyTo == "" {
+		return nil
+	}
+	reply := Reply{SagaID: cmd.SagaID, Step: cmd.Step}
+	if err != nil {
+		reply.Error = err.Error()
+	} else if data != nil {
+		b, err := json.Marshal(data)
+		if err != nil {
+			return errors.New("encode reply data", err, op, errors.Invalid)
+		}
+		reply.Data = b
+	}
+	if err := publisher.Publish(ctx, cmd.ReplyTo, reply); err != nil {
+		return errors.New("publish reply to "+cmd.ReplyTo, err, op, errors.IO)
+	}
+	return nil
+}
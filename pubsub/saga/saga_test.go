@@ -0,0 +1,208 @@
+package saga_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/etherlabsio/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/etherlabsio/pkg/pubsub/mem"
+	pubsubnats "github.com/etherlabsio/pkg/pubsub/nats"
+	"github.com/etherlabsio/pkg/pubsub/saga"
+	"github.com/etherlabsio/pkg/redis/redistest"
+)
+
+type order struct {
+	ID       string `json:"id"`
+	Reserved bool   `json:"reserved,omitempty"`
+	Charged  bool   `json:"charged,omitempty"`
+}
+
+var orderSaga = saga.Definition{
+	Name:         "order",
+	ReplySubject: "order.saga.reply",
+	Steps: []saga.Step{
+		{Name: "reserve", Command: "stock.reserve", Compensation: "stock.release"},
+		{Name: "charge", Command: "payment.charge", Compensation: "payment.refund"},
+		{Name: "ship", Command: "shipping.schedule"},
+	},
+}
+
+func setup(t *testing.T, def saga.Definition, opts ...saga.Option) (*saga.Orchestrator, *mem.Bus) {
+	bus := mem.NewBus()
+	o, err := saga.New(def, redistest.NewClient(t), bus, opts...)
+	require.NoError(t, err)
+	bus.SubscribeHandler(def.ReplySubject, o.Handler())
+	return o, bus
+}
+
+// service serves the commands published to subject with f.
+func service(bus *mem.Bus, subject string, f func(o order) (interface{}, error)) {
+	bus.SubscribeHandler(subject, pubsubnats.NewSubscriber(
+		func(ctx context.Context, request interface{}) (interface{}, error) {
+			cmd := request.(saga.Command)
+			var o order
+			if err := json.Unmarshal(cmd.Data, &o); err != nil {
+				return nil, err
+			}
+			data, err := f(o)
+			return nil, saga.Respond(ctx, bus, cmd, data, err)
+		},
+		saga.DecodeCommand,
+	))
+}
+
+func subjects(bus *mem.Bus) []string {
+	var s []string
+	for _, msg := range bus.Published() {
+		s = append(s, msg.Subject)
+	}
+	return s
+}
+
+func TestOrchestrator_Completes(t *testing.T) {
+	o, bus := setup(t, orderSaga)
+	service(bus, "stock.reserve", func(o order) (interface{}, error) {
+		o.Reserved = true
+		return o, nil
+	})
+	service(bus, "payment.charge", func(o order) (interface{}, error) {
+		o.Charged = true
+		return o, nil
+	})
+	service(bus, "shipping.schedule", func(order) (interface{}, error) { return nil, nil })
+
+	ctx := context.Background()
+	require.NoError(t, o.Start(ctx, "42", order{ID: "42"}))
+
+	s, err := o.Get(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, saga.Completed, s.Status)
+	assert.JSONEq(t, `{"id":"42","reserved":true,"charged":true}`, string(s.Data))
+	assert.Equal(t, []string{
+		"stock.reserve", "order.saga.reply",
+		"payment.charge", "order.saga.reply",
+		"shipping.schedule", "order.saga.reply",
+	}, subjects(bus))
+
+	err = o.Start(ctx, "42", order{ID: "42"})
+	assert.True(t, errors.IsKind(err, errors.AlreadyExist), "got %v", err)
+}
+
+func TestOrchestrator_CompensatesFailedStep(t *testing.T) {
+	o, bus := setup(t, orderSaga)
+	service(bus, "stock.reserve", func(o order) (interface{}, error) { return nil, nil })
+	service(bus, "payment.charge", func(o order) (interface{}, error) { return nil, nil })
+	service(bus, "shipping.schedule", func(order) (interface{}, error) {
+		return nil, errors.New("no carrier available")
+	})
+
+	ctx := context.Background()
+	require.NoError(t, o.Start(ctx, "42", order{ID: "42"}))
+
+	s, err := o.Get(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, saga.Aborted, s.Status)
+	assert.Equal(t, 2, s.Step)
+	assert.Contains(t, s.Error, "step ship failed: no carrier available")
+
+	assert.Equal(t, []string{
+		"stock.reserve", "order.saga.reply",
+		"payment.charge", "order.saga.reply",
+		"shipping.schedule", "order.saga.reply",
+		"payment.refund", "stock.release",
+	}, subjects(bus))
+
+	released := bus.PublishedTo("stock.release")
+	require.Len(t, released, 1)
+	var cmd saga.Command
+	require.NoError(t, json.Unmarshal(released[0].Data, &cmd))
+	assert.Equal(t, saga.Command{SagaID: "42", Saga: "order", Step: 0, Data: json.RawMessage(`{"id":"42"}`)}, cmd)
+}
+
+func TestOrchestrator_IgnoresStaleReplies(t *testing.T) {
+	o, bus := setup(t, orderSaga)
+	ctx := context.Background()
+	require.NoError(t, o.Start(ctx, "42", order{ID: "42"}))
+
+	require.NoError(t, o.Handle(ctx, saga.Reply{SagaID: "42", Step: 0}))
+	require.NoError(t, o.Handle(ctx, saga.Reply{SagaID: "42", Step: 0, Error: "duplicate"}))
+
+	s, err := o.Get(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, saga.Running, s.Status)
+	assert.Equal(t, 1, s.Step)
+	assert.Equal(t, []string{"stock.reserve", "payment.charge"}, subjects(bus))
+
+	err = o.Handle(ctx, saga.Reply{SagaID: "unknown"})
+	assert.True(t, errors.IsKind(err, errors.NotExist), "got %v", err)
+}
+
+func TestOrchestrator_TimesOutStep(t *testing.T) {
+	def := orderSaga
+	def.Steps = append([]saga.Step(nil), orderSaga.Steps...)
+	def.Steps[1].Timeout = 20 * time.Millisecond
+	o, bus := setup(t, def, saga.CheckInterval(5*time.Millisecond))
+	service(bus, "stock.reserve", func(o order) (interface{}, error) { return nil, nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go o.Run(ctx)
+
+	require.NoError(t, o.Start(ctx, "42", order{ID: "42"}))
+	require.Eventually(t, func() bool {
+		s, err := o.Get(ctx, "42")
+		return err == nil && s.Status == saga.Aborted
+	}, time.Second, 5*time.Millisecond)
+
+	s, err := o.Get(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, "step charge timed out", s.Error)
+	assert.Len(t, bus.PublishedTo("stock.release"), 1)
+	assert.Empty(t, bus.PublishedTo("payment.refund"))
+}
+
+func TestOrchestrator_ResendsCommandWithoutTimeout(t *testing.T) {
+	o, bus := setup(t, orderSaga, saga.CheckInterval(5*time.Millisecond), saga.CommandRetry(20*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go o.Run(ctx)
+
+	// the command is lost: nobody serves stock.reserve yet
+	require.NoError(t, o.Start(ctx, "42", order{ID: "42"}))
+	service(bus, "stock.reserve", func(o order) (interface{}, error) { return nil, nil })
+
+	require.Eventually(t, func() bool {
+		s, err := o.Get(ctx, "42")
+		return err == nil && s.Step == 1
+	}, time.Second, 5*time.Millisecond)
+
+	s, err := o.Get(ctx, "42")
+	require.NoError(t, err)
+	assert.Equal(t, saga.Running, s.Status)
+	assert.Len(t, bus.PublishedTo("stock.reserve"), 2)
+}
+
+func TestNew_InvalidDefinition(t *testing.T) {
+	tests := []struct {
+		name string
+		def  saga.Definition
+	}{
+		{"no name", saga.Definition{ReplySubject: "reply", Steps: orderSaga.Steps}},
+		{"no reply subject", saga.Definition{Name: "order", Steps: orderSaga.Steps}},
+		{"no steps", saga.Definition{Name: "order", ReplySubject: "reply"}},
+		{"no command", saga.Definition{Name: "order", ReplySubject: "reply", Steps: []saga.Step{{Name: "reserve"}}}},
+		{"negative timeout", saga.Definition{Name: "order", ReplySubject: "reply", Steps: []saga.Step{{Name: "reserve", Command: "stock.reserve", Timeout: -time.Second}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := saga.New(tt.def, nil, nil)
+			assert.True(t, errors.IsKind(err, errors.Invalid), "got %v", err)
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package pubsub
+
+import (
+	"context"
+)
+
+// Message is a minimal, transport neutral view of a message received from a
+// message bus.
+type Message interface {
+	// Subject the message was published to.
+	Subject() string
+	// Data is the encoded payload.
+	Data() []byte
+	// Header returns the message headers, which may be empty.
+	Header() map[string][]string
+	// Ack acknowledges the message as processed.
+	Ack() error
+	// Nak signals the message could not be processed and should be redelivered.
+	Nak() error
+}
+
+// Handler processes messages.
+//
+// Unless the handler acknowledges the message itself, transports ack the
+// message when the handler returns nil and nak it otherwise. Transports
+// without acknowledgements treat Ack and Nak as no-ops.
+type Handler interface {
+	HandleMessage(ctx context.Context, msg Message) error
+}
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as
+// message handlers.
+type HandlerFunc func(ctx context.Context, msg Message) error
+
+// HandleMessage calls f(ctx, msg).
+func (f HandlerFunc) HandleMessage(ctx context.Context, msg Message) error {
+	return f(ctx, msg)
+}
+
+// Subscription is an active interest in a subject.
+type Subscription interface {
+	Unsubscribe() error
+}
+
+// Subscriber is a minimal interface for subscribing handlers to the messages
+// published on a message bus. As for the Publisher, transport specific
+// settings belong to the concrete constructor.
+type Subscriber interface {
+	Subscribe(ctx context.Context, subject string, h Handler) (Subscription, error)
+}
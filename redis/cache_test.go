@@ -6,7 +6,7 @@ import (
 	"log"
 	"testing"
 
-	"github.com/alicebob/miniredis"
+	"github.com/alicebob/miniredis/v2"
 )
 
 func TestCache_CheckUmarshalling(t *testing.T) {
@@ -0,0 +1,23 @@
+// Package redistest provides an in-memory Redis server for tests.
+package redistest
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/etherlabsio/pkg/redis"
+)
+
+// NewClient starts an in-memory Redis server, closed when the test ends, and
+// returns a client connected to it. The server implements streams and
+// consumer groups.
+func NewClient(t testing.TB) *redis.Client {
+	t.Helper()
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return redis.NewClient(redis.Addresses(s.Addr()))
+}
@@ -0,0 +1,121 @@
+// Package requestid carries the ID of a request from the HTTP handler that
+// received it to the logs and NATS messages it leads to, so that they can be
+// correlated.
+//
+// Example:
+//
+//	h = httputil.Chain(httputil.AccessLog(logger), requestid.Middleware())(h)
+//
+//	publisher := pubsubnats.NewPublisher(nc, pubsubnats.PublisherPropagators(requestid.Propagator()))
+//	subscriber := pubsubnats.NewSubscriber(e, dec, pubsubnats.SubscriberPropagators(requestid.Propagator()))
+//
+//	// in the handlers and endpoints
+//	logger := requestid.Logger(ctx, logger)
+package requestid
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nuid"
+
+	"github.com/etherlabsio/pkg/httputil"
+	pubsubnats "github.com/etherlabsio/pkg/pubsub/nats"
+)
+
+// Header is the HTTP and NATS header carrying the request ID.
+const Header = httputil.RequestIDHeader
+
+// maxLen bounds the length of the accepted request IDs.
+const maxLen = 128
+
+type contextKey int
+
+const requestIDKey contextKey = 0
+
+// New returns a new unique request ID.
+func New() string {
+	return nuid.Next()
+}
+
+// NewContext returns a copy of ctx carrying the request ID. The ID also
+// becomes the pubsubnats correlation ID of ctx unless it already has one, so
+// that a flow started by a request has a single ID.
+func NewContext(ctx context.Context, id string) context.Context {
+	if _, ok := pubsubnats.CorrelationIDFromContext(ctx); !ok {
+		ctx = pubsubnats.ContextWithCorrelationID(ctx, id)
+	}
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// FromContext returns the request ID carried by ctx, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey).(string)
+	return id, ok
+}
+
+// Logger returns logger with the request ID carried by ctx, if any, under the
+// "request_id" key.
+func Logger(ctx context.Context, logger log.Logger) log.Logger {
+	if id, ok := FromContext(ctx); ok {
+		return log.With(logger, "request_id", id)
+	}
+	return logger
+}
+
+// valid reports whether id is short and made of printable ASCII characters, so
+// that clients cannot forge log lines through it.
+func valid(id string) bool {
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware returns a Middleware storing the ID of the request in its
+// context, as the request ID and the correlation ID. The ID is taken from the
+// X-Request-ID header if valid, generated otherwise, and returned in the
+// X-Request-ID header of the response.
+func Middleware() httputil.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(Header)
+			if !valid(id) {
+				id = New()
+			}
+			w.Header().Set(Header, id)
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+		})
+	}
+}
+
+// Propagator returns a pubsubnats.Propagator carrying the request ID in the
+// X-Request-ID message header.
+func Propagator() pubsubnats.Propagator {
+	return propagator{}
+}
+
+type propagator struct{}
+
+func (propagator) Inject(ctx context.Context, h nats.Header) {
+	if h.Get(Header) != "" {
+		return
+	}
+	if id, ok := FromContext(ctx); ok {
+		h.Set(Header, id)
+	}
+}
+
+func (propagator) Extract(ctx context.Context, h nats.Header) context.Context {
+	if id := h.Get(Header); valid(id) {
+		return NewContext(ctx, id)
+	}
+	return ctx
+}
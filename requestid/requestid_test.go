@@ -0,0 +1,134 @@
+package requestid_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats-server/v2/server"
+	natsserver "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	pubsubnats "github.com/etherlabsio/pkg/pubsub/nats"
+	"github.com/etherlabsio/pkg/requestid"
+)
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+		accepted bool
+	}{
+		{"accepted", "req-1", true},
+		{"missing", "", false},
+		{"not printable", "req 1\nlevel=error", false},
+		{"too long", strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := requestid.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = requestid.FromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.incoming != "" {
+				r.Header.Set(requestid.Header, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			require.NotEmpty(t, got)
+			assert.Equal(t, got, w.Header().Get(requestid.Header))
+			if tt.accepted {
+				assert.Equal(t, tt.incoming, got)
+			} else {
+				assert.NotEqual(t, tt.incoming, got)
+			}
+		})
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.NewLogfmtLogger(&buf)
+
+	requestid.Logger(context.Background(), logger).Log("msg", "without")
+	requestid.Logger(requestid.NewContext(context.Background(), "req-1"), logger).Log("msg", "with")
+
+	assert.Equal(t, "msg=without\nrequest_id=req-1 msg=with\n", buf.String())
+}
+
+func TestPropagator_HTTPToSubscriber(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	s := natsserver.RunServer(&opts)
+	defer s.Shutdown()
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+
+	received := make(chan string, 1)
+	sub, err := nc.Subscribe("orders.created", pubsubnats.NewSubscriber(
+		func(ctx context.Context, _ interface{}) (interface{}, error) {
+			id, _ := requestid.FromContext(ctx)
+			received <- id
+			return nil, nil
+		},
+		pubsubnats.NopRequestDecoder,
+		pubsubnats.SubscriberPropagators(requestid.Propagator()),
+	).ServeMsg(nc))
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	p := pubsubnats.NewPublisher(nc, pubsubnats.PublisherPropagators(requestid.Propagator()))
+	h := requestid.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, p.Publish(r.Context(), "orders.created", "payload"))
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	r.Header.Set(requestid.Header, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	select {
+	case id := <-received:
+		assert.Equal(t, "req-1", id)
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+}
+
+func TestMiddleware_CorrelationID(t *testing.T) {
+	opts := natsserver.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	s := natsserver.RunServer(&opts)
+	defer s.Shutdown()
+	nc, err := nats.Connect(s.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+
+	sub, err := nc.SubscribeSync("orders.created")
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+
+	p := pubsubnats.NewPublisher(nc, pubsubnats.PublisherPropagators(pubsubnats.CorrelationID(), requestid.Propagator()))
+	h := requestid.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := pubsubnats.CorrelationIDFromContext(r.Context())
+		assert.Equal(t, "req-1", id)
+		require.NoError(t, p.Publish(r.Context(), "orders.created", "payload"))
+	}))
+	r := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	r.Header.Set(requestid.Header, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	msg, err := sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "req-1", msg.Header.Get(pubsubnats.CorrelationIDHdr))
+	assert.Equal(t, "req-1", msg.Header.Get(requestid.Header))
+}